@@ -3,16 +3,28 @@ package main
 import (
 	"context"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
+	"github.com/IBM/sarama"
+	"github.com/cockroachdb/errors"
 	"github.com/rs/zerolog/log"
 	"order_service/internal/api"
 	"order_service/internal/cache"
 	"order_service/internal/config"
+	"order_service/internal/db/migrations"
 	"order_service/internal/db/postgres"
+	"order_service/internal/db/redis"
+	"order_service/internal/lifecycle"
+	"order_service/internal/metrics"
+	"order_service/internal/model"
+	"order_service/internal/outbox"
 	"order_service/internal/processor"
 	"order_service/internal/repository"
 	"order_service/internal/service"
+	"order_service/internal/tracing"
 )
 
 func main() {
@@ -21,42 +33,306 @@ func main() {
 		log.Fatal().Stack().Err(err).Send()
 	}
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = migrate(ctx, cf.DatabaseURL, os.Args[2:]); err != nil {
+			log.Fatal().Stack().Err(err).Send()
+		}
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cf.OTLPEndpoint, cf.OTLPInsecure)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Send()
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), cf.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Error().Stack().Err(err).Send()
+		}
+	}()
+
 	pool, err := postgres.Pool(ctx, cf.DatabaseURL)
 	if err != nil {
 		log.Fatal().Stack().Err(err).Send()
 	}
 	defer pool.Close()
 
-	svc := service.New(repository.New(pool), cache.New(cf.Capacity, cf.TTL), cf.Limit)
+	migrator, err := migrations.New(pool)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Send()
+	}
+	if err = migrator.Check(ctx); err != nil {
+		log.Fatal().Stack().Err(err).Send()
+	}
+
+	if err = metrics.RegisterPool(pool); err != nil {
+		log.Fatal().Stack().Err(err).Send()
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(3)
+	localCache := cache.NewSharded[string, model.Order](cf.CacheShards, cf.Capacity, cf.TTL,
+		cache.WithName("orders"),
+		cache.WithJanitor(cf.CacheJanitor),
+		cache.WithStaleGrace(cf.CacheStaleGrace),
+		cache.WithEvictionCallback(func(key string, _ model.Order, reason cache.EvictionReason) {
+			log.Debug().Str("order_id", key).Stringer("reason", reason).Msg("Order left cache")
+		}),
+	)
+	defer localCache.Stop()
 
-	go func() {
-		defer wg.Done()
-		if err = svc.WarmUpCache(ctx); err != nil {
+	var orderCache service.Cache = localCache
+	var remoteCache *redis.Cache
+	if cf.RedisURL != "" {
+		client, err := redis.Client(ctx, cf.RedisURL)
+		if err != nil {
 			log.Fatal().Stack().Err(err).Send()
 		}
-	}()
+		defer func() { _ = client.Close() }()
+
+		remoteCache = redis.NewCache(client, cf.RedisTTL)
+		// Redis is not a readiness check: without it orders are still served
+		// from Postgres. Its failures show up in order_service_redis_errors_total.
+		orderCache = cache.NewTiered[string, model.Order](localCache, remoteCache)
+	}
 
-	a := api.New(svc)
-	go func() {
-		defer wg.Done()
-		if err = http.ListenAndServe(cf.Addr, a); err != nil {
+	// In-process caches go stale when another replica changes an order, so
+	// they are invalidated through Postgres notifications. A notification of
+	// a revision this replica has already cached, such as one of its own
+	// writes, evicts nothing. Otherwise the order is dropped from Redis too:
+	// a load that read the previous revision may have stored it there just
+	// before the notification arrived.
+	local := []interface {
+		Delete(key string)
+		Purge()
+	}{localCache}
+
+	opts := []service.Option{service.WithIdempotency(cf.Idempotency.Lease, cf.Idempotency.TTL)}
+	var snapshot *cache.SnapshotFile
+	if cf.CacheSnapshot != "" {
+		snapshot = cache.NewSnapshotFile(cf.CacheSnapshot, localCache)
+		opts = append(opts, service.WithSnapshot(snapshot))
+	}
+	if len(cf.WarmUp.Strategies) > 0 {
+		strategies, err := warmUpStrategies(cf.WarmUp.Strategies)
+		if err != nil {
 			log.Fatal().Stack().Err(err).Send()
 		}
-	}()
+		opts = append(opts, service.WithWarmUp(cf.WarmUp.BatchSize, strategies...))
+	}
+	if cf.NegativeCache.Capacity > 0 {
+		notFound := cache.New[string, struct{}](cf.NegativeCache.Capacity, cf.NegativeCache.TTL,
+			cache.WithName("negative"), cache.WithJanitor(cf.CacheJanitor))
+		defer notFound.Stop()
+		opts = append(opts, service.WithNegativeCache(notFound))
+		local = append(local, notFound)
+	}
+
+	repo := repository.New(pool)
+	svc := service.New(repo, orderCache, cf.Limit, opts...)
+
+	listener := postgres.NewListener(postgres.PoolConnector(pool), repository.ChangesChannel,
+		func(payload string) {
+			orderID, revision, err := repository.ParseChange(payload)
+			if err != nil {
+				log.Warn().Err(err).Msg("Ignoring change notification")
+				return
+			}
+			if !svc.Changed(orderID, revision) {
+				return
+			}
+			for _, c := range local {
+				c.Delete(orderID.String())
+			}
+			if remoteCache != nil {
+				remoteCache.Delete(orderID.String())
+			}
+		},
+		func() {
+			for _, c := range local {
+				c.Purge()
+			}
+		},
+	)
+
+	p := processor.New(cf.Brokers, processor.Topics{
+		Orders:       cf.Topics,
+		Commands:     cf.CommandTopic,
+		StatusEvents: cf.StatusTopic,
+		DeadLetter:   cf.DLQTopic,
+	}, cf.GroupID, processor.RetryPolicy{
+		MaxAttempts:          cf.Retry.MaxAttempts,
+		UnknownOrderAttempts: cf.Retry.UnknownOrderAttempts,
+		InitialBackoff:       cf.Retry.InitialBackoff,
+		MaxBackoff:           cf.Retry.MaxBackoff,
+	}, svc)
 
-	p := processor.New(cf.Brokers, cf.Topics, cf.GroupID, svc)
-	go func() {
-		defer wg.Done()
-		defer func() { _ = p.Stop() }()
-		if err = p.Start(ctx); err != nil {
+	var relay *outbox.Relay
+	var producer sarama.SyncProducer
+	if cf.Outbox.Topic != "" {
+		producer, err = outbox.NewProducer(cf.Brokers)
+		if err != nil {
 			log.Fatal().Stack().Err(err).Send()
 		}
-	}()
 
-	wg.Wait()
+		relay = outbox.New(repo, producer, outbox.Config{
+			Topic:          cf.Outbox.Topic,
+			Interval:       cf.Outbox.Interval,
+			BatchSize:      cf.Outbox.BatchSize,
+			Lease:          cf.Outbox.Lease,
+			InitialBackoff: cf.Outbox.InitialBackoff,
+			MaxBackoff:     cf.Outbox.MaxBackoff,
+		})
+	}
+
+	srv := &http.Server{
+		Addr: cf.Addr,
+		Handler: api.New(svc,
+			api.ReadinessCheck{Name: "postgres", Check: pool.Ping},
+			api.ReadinessCheck{Name: "kafka", Check: p.Check},
+			api.ReadinessCheck{Name: "cache", Check: svc.CheckWarmUp},
+		),
+	}
+
+	components := []lifecycle.Component{
+		{
+			Name: "cache warm-up",
+			Run:  svc.WarmUpCache,
+		},
+		{
+			Name: "cache invalidation",
+			Run:  listener.Run,
+		},
+		{
+			Name: "http server",
+			Run: func(_ context.Context) error {
+				if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					return errors.WithStack(err)
+				}
+				return nil
+			},
+			Stop: srv.Shutdown,
+		},
+		{
+			Name: "kafka consumer",
+			Run: func(ctx context.Context) error {
+				defer func() {
+					if err := p.Stop(); err != nil {
+						log.Error().Stack().Err(err).Send()
+					}
+				}()
+				return p.Start(ctx)
+			},
+		},
+	}
+	if cf.Idempotency.Interval > 0 {
+		components = append(components, lifecycle.Component{
+			Name: "idempotency key expiry",
+			Run: func(ctx context.Context) error {
+				return svc.ExpireIdempotencyKeys(ctx, cf.Idempotency.Interval)
+			},
+		})
+	}
+	if relay != nil {
+		components = append(components, lifecycle.Component{
+			Name: "outbox relay",
+			Run: func(ctx context.Context) error {
+				defer func() {
+					if err := producer.Close(); err != nil {
+						log.Error().Stack().Err(err).Send()
+					}
+				}()
+				return relay.Run(ctx)
+			},
+		})
+	}
+
+	// The pool is closed by the deferred call above only after every component
+	// has stopped, so in-flight requests, messages and outbox events can still
+	// reach Postgres.
+	err = lifecycle.Run(ctx, cf.ShutdownTimeout, components...)
+	if err != nil {
+		log.Error().Stack().Err(err).Send()
+	}
+
+	// Every component has stopped, so the cache no longer changes.
+	if snapshot != nil {
+		if err = snapshot.Save(); err != nil {
+			log.Error().Stack().Err(err).Msg("Failed to save cache snapshot")
+		} else {
+			log.Info().Str("path", cf.CacheSnapshot).Msg("Cache snapshot saved")
+		}
+	}
+
+	log.Info().Msg("Service stopped")
+}
+
+func warmUpStrategies(configs []config.WarmUpStrategyConfig) ([]service.WarmUpStrategy, error) {
+	strategies := make([]service.WarmUpStrategy, 0, len(configs))
+	for _, c := range configs {
+		switch c.Name {
+		case "recent":
+			strategies = append(strategies, service.RecentOrders{Limit: c.Limit})
+		case "active":
+			strategies = append(strategies, service.ActiveOrders{Limit: c.Limit})
+		case "touched":
+			if c.Window <= 0 {
+				return nil, errors.Newf("warm-up strategy %q needs a positive window", c.Name)
+			}
+			strategies = append(strategies, service.TouchedOrders{Window: c.Window, Limit: c.Limit})
+		default:
+			return nil, errors.Newf("unknown warm-up strategy %q, expected recent, active or touched", c.Name)
+		}
+	}
+
+	return strategies, nil
+}
+
+// migrate implements the "migrate [up | down [steps] | version]" subcommand.
+func migrate(ctx context.Context, databaseURL string, args []string) error {
+	pool, err := postgres.Pool(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	migrator, err := migrations.New(pool)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.Newf("invalid number of steps %q", args[1])
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "version":
+	default:
+		return errors.Newf("unknown migrate command %q, expected up, down or version", command)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
 
+	log.Info().Int("version", version).Int("latest", migrator.Latest()).Msg("Schema version")
+	return nil
 }
@@ -2,54 +2,146 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"order_service/internal/metrics"
 	"order_service/internal/model"
+	"order_service/internal/processor"
+	"order_service/internal/tracing"
 )
 
 type Service interface {
 	Order(ctx context.Context, orderID uuid.UUID) (model.Order, error)
+	Orders(ctx context.Context, filter model.OrderFilter) (model.OrderPage, error)
+	History(ctx context.Context, orderID uuid.UUID) ([]model.StatusChange, error)
+	ProcessOrder(ctx context.Context, order model.Order) error
+	ReserveIdempotencyKey(ctx context.Context, key, requestHash string) (model.IdempotentResponse, bool, error)
+	SaveIdempotentResponse(ctx context.Context, resp model.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, key, requestHash string) error
+	UpdateItemStatus(ctx context.Context, orderID, rid uuid.UUID, status model.ItemStatus,
+		reason string, version int64) (model.Order, error)
+	CancelOrder(ctx context.Context, orderID uuid.UUID, reason string) (model.Order, error)
+	ReturnItems(ctx context.Context, orderID uuid.UUID, rids []uuid.UUID, reason string) (model.Order, error)
 }
 
+const (
+	// IdempotencyKeyHeader makes POST /orders safe to retry: the response to
+	// the first request with a key is stored and replayed for later ones.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks replayed responses.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxOrderSize = 1 << 20
+)
+
 type API struct {
 	*echo.Echo
 	service Service
+	checks  []ReadinessCheck
 }
 
-func New(service Service) *API {
+func New(service Service, checks ...ReadinessCheck) *API {
 	a := &API{
 		Echo:    echo.New(),
 		service: service,
+		checks:  checks,
 	}
 
+	a.Use(otelecho.Middleware(tracing.ServiceName))
+	a.Use(a.observeRequest)
+
 	a.Static("/static", "/static")
 
 	a.GET("/order/:id", a.order)
+	a.GET("/order/:id/history", a.history)
+	a.PATCH("/order/:id/items/:rid", a.updateItem)
+	a.POST("/order/:id/cancel", a.cancelOrder)
+	a.POST("/order/:id/returns", a.returnItems)
+	a.GET("/orders", a.orders)
+	a.POST("/orders", a.createOrder)
 	a.GET("/", a.serveIndex)
 
+	a.GET("/healthz", a.healthz)
+	a.GET("/readyz", a.readyz)
+	a.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+
 	return a
 }
 
+// observeRequest records request latency labelled by the matched route
+// template rather than the raw path, to keep label cardinality bounded.
+func (a *API) observeRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		metrics.HTTPRequestDuration.
+			WithLabelValues(c.Request().Method, route, strconv.Itoa(c.Response().Status)).
+			Observe(time.Since(start).Seconds())
+
+		return nil
+	}
+}
+
 func (a *API) serveIndex(c echo.Context) error {
 	return c.File("/static/index.html")
 }
 
 func (a *API) order(c echo.Context) error {
-	id := c.Param("id")
-	if id == "" {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
 	}
 
-	orderID, err := uuid.Parse(id)
+	order, err := a.service.Order(c.Request().Context(), orderID)
+	if err != nil {
+		if errors.Is(err, model.ErrOrderNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"reason": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
+	}
+
+	resp := a.orderFromModel(order)
+	if c.QueryParam("include") == "history" {
+		history, err := a.service.History(c.Request().Context(), orderID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
+		}
+		resp.History = a.historyFromModels(history)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) history(c echo.Context) error {
+	orderID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
 	}
 
-	order, err := a.service.Order(c.Request().Context(), orderID)
+	history, err := a.service.History(c.Request().Context(), orderID)
 	if err != nil {
 		if errors.Is(err, model.ErrOrderNotFound) {
 			return c.JSON(http.StatusNotFound, echo.Map{"reason": err.Error()})
@@ -57,9 +149,376 @@ func (a *API) order(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
 	}
 
+	return c.JSON(http.StatusOK, HistoryResponse{
+		OrderID: orderID,
+		History: a.historyFromModels(history),
+	})
+}
+
+type UpdateItemRequest struct {
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Version int64  `json:"version"`
+}
+
+// updateItem changes the status of an order item. The expected item version
+// comes from If-Match (an item ETag or "*") or from the request body.
+func (a *API) updateItem(c echo.Context) error {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	var req UpdateItemRequest
+	if err = json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	status := model.ItemStatus(req.Status)
+	if !status.Valid() {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "unknown item status"})
+	}
+
+	version := req.Version
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" {
+		version, err = parseItemETag(ifMatch)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid If-Match header"})
+		}
+	} else if version == 0 {
+		return c.JSON(http.StatusPreconditionRequired,
+			echo.Map{"reason": "item version is required in If-Match or the request body"})
+	}
+
+	order, err := a.service.UpdateItemStatus(c.Request().Context(), orderID, rid, status, req.Reason, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrReasonRequired):
+			return c.JSON(http.StatusBadRequest, echo.Map{"reason": err.Error()})
+		case errors.Is(err, model.ErrOrderNotFound), errors.Is(err, model.ErrItemNotFound):
+			return c.JSON(http.StatusNotFound, echo.Map{"reason": err.Error()})
+		case errors.Is(err, model.ErrVersionConflict):
+			return c.JSON(http.StatusPreconditionFailed, echo.Map{"reason": err.Error()})
+		case errors.Is(err, model.ErrInvalidTransition):
+			return c.JSON(http.StatusConflict, echo.Map{"reason": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
+	}
+
+	for _, item := range order.Items {
+		if item.ID == rid {
+			c.Response().Header().Set("ETag", itemETag(item.Version))
+		}
+	}
+
+	return c.JSON(http.StatusOK, a.orderFromModel(order))
+}
+
+type CancelOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ReturnItemsRequest struct {
+	Items  []uuid.UUID `json:"items"`
+	Reason string      `json:"reason"`
+}
+
+func (a *API) cancelOrder(c echo.Context) error {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	var req CancelOrderRequest
+	if err = json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	order, err := a.service.CancelOrder(c.Request().Context(), orderID, req.Reason)
+	if err != nil {
+		return a.itemsChangeError(c, err)
+	}
+
 	return c.JSON(http.StatusOK, a.orderFromModel(order))
 }
 
+// returnItems returns the items listed in the request, or the whole order if
+// there are none.
+func (a *API) returnItems(c echo.Context) error {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	var req ReturnItemsRequest
+	if err = json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	order, err := a.service.ReturnItems(c.Request().Context(), orderID, req.Items, req.Reason)
+	if err != nil {
+		return a.itemsChangeError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, a.orderFromModel(order))
+}
+
+// itemsChangeError responds to a cancellation or return the service refused.
+func (a *API) itemsChangeError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, model.ErrReasonRequired):
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": err.Error()})
+	case errors.Is(err, model.ErrOrderNotFound), errors.Is(err, model.ErrItemNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"reason": err.Error()})
+	case errors.Is(err, model.ErrVersionConflict):
+		return c.JSON(http.StatusPreconditionFailed, echo.Map{"reason": err.Error()})
+	case errors.Is(err, model.ErrInvalidTransition):
+		return c.JSON(http.StatusConflict, echo.Map{"reason": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
+}
+
+func itemETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseItemETag returns the version of a strong item ETag; "*" matches any
+// version and yields zero.
+func parseItemETag(etag string) (int64, error) {
+	etag = strings.TrimSpace(etag)
+	if etag == "*" {
+		return 0, nil
+	}
+
+	unquoted, err := strconv.Unquote(etag)
+	if err != nil || !strings.HasPrefix(etag, `"`) {
+		return 0, errors.Newf("malformed ETag %q", etag)
+	}
+
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 1 {
+		return 0, errors.Newf("malformed ETag %q", etag)
+	}
+
+	return version, nil
+}
+
+func (a *API) orders(c echo.Context) error {
+	filter, err := a.orderFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	page, err := a.service.Orders(c.Request().Context(), filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, a.ordersFromPage(page))
+}
+
+// createOrder accepts an order in the same format as the orders topic.
+func (a *API) createOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxOrderSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{"reason": "order is too large"})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"reason": "invalid request format or params"})
+	}
+
+	key := c.Request().Header.Get(IdempotencyKeyHeader)
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	// The key is reserved before processing, so that a concurrent duplicate
+	// is not processed as well.
+	if key != "" {
+		stored, reserved, err := a.service.ReserveIdempotencyKey(ctx, key, hash)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
+		}
+		if !reserved {
+			return a.replay(c, stored, hash)
+		}
+	}
+
+	status, resp := a.processOrder(ctx, body)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(echo.Map{"reason": err.Error()})
+	}
+
+	// The reservation is completed even if the client has gone away, so that
+	// its retry is not blocked until the reservation expires.
+	ctx = context.WithoutCancel(ctx)
+	switch {
+	case key == "":
+	case status >= http.StatusInternalServerError:
+		// Server errors are not stored so that a retry can still succeed.
+		if err = a.service.ReleaseIdempotencyKey(ctx, key, hash); err != nil {
+			log.Error().Stack().Err(err).Str("key", key).Msg("Failed to release idempotency key")
+		}
+	default:
+		err = a.service.SaveIdempotentResponse(ctx, model.IdempotentResponse{
+			Key:         key,
+			RequestHash: hash,
+			StatusCode:  status,
+			Body:        data,
+		})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"reason": err.Error()})
+		}
+	}
+
+	return c.JSONBlob(status, data)
+}
+
+func (a *API) processOrder(ctx context.Context, body []byte) (int, any) {
+	order, err := processor.DecodeOrder(body)
+	if err != nil {
+		return http.StatusBadRequest, echo.Map{"reason": err.Error()}
+	}
+
+	err = a.service.ProcessOrder(ctx, order)
+	if err != nil {
+		return http.StatusInternalServerError, echo.Map{"reason": err.Error()}
+	}
+
+	// Read the order back: stored item statuses may differ from the request.
+	stored, err := a.service.Order(ctx, order.ID)
+	if err != nil {
+		return http.StatusInternalServerError, echo.Map{"reason": err.Error()}
+	}
+
+	return http.StatusCreated, a.orderFromModel(stored)
+}
+
+func (a *API) replay(c echo.Context, stored model.IdempotentResponse, hash string) error {
+	if stored.RequestHash != hash {
+		return c.JSON(http.StatusUnprocessableEntity,
+			echo.Map{"reason": "idempotency key has already been used for a different request"})
+	}
+	if stored.Pending() {
+		return c.JSON(http.StatusConflict,
+			echo.Map{"reason": "a request with this idempotency key is still being processed"})
+	}
+
+	c.Response().Header().Set(IdempotentReplayedHeader, "true")
+	return c.JSONBlob(stored.StatusCode, stored.Body)
+}
+
+func (a *API) orderFilter(c echo.Context) (model.OrderFilter, error) {
+	filter := model.OrderFilter{
+		TrackNumber:     c.QueryParam("track_number"),
+		DeliveryService: c.QueryParam("delivery_service"),
+		Locale:          c.QueryParam("locale"),
+		ItemStatus:      model.ItemStatus(c.QueryParam("status")),
+	}
+
+	if filter.ItemStatus != "" && !filter.ItemStatus.Valid() {
+		return model.OrderFilter{}, errors.Newf("unknown item status %q", filter.ItemStatus)
+	}
+
+	var err error
+	if v := c.QueryParam("customer_id"); v != "" {
+		filter.CustomerID, err = uuid.Parse(v)
+		if err != nil {
+			return model.OrderFilter{}, errors.WithStack(err)
+		}
+	}
+
+	if v := c.QueryParam("created_from"); v != "" {
+		filter.CreatedFrom, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return model.OrderFilter{}, errors.WithStack(err)
+		}
+	}
+
+	if v := c.QueryParam("created_to"); v != "" {
+		filter.CreatedTo, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return model.OrderFilter{}, errors.WithStack(err)
+		}
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		filter.Limit, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return model.OrderFilter{}, errors.WithStack(err)
+		}
+	}
+
+	if v := c.QueryParam("page_token"); v != "" {
+		filter.After, err = decodePageToken(v)
+		if err != nil {
+			return model.OrderFilter{}, err
+		}
+	}
+
+	return filter, nil
+}
+
+// encodePageToken packs a cursor into an opaque URL-safe token.
+func encodePageToken(cursor *model.OrderCursor) string {
+	if cursor == nil {
+		return ""
+	}
+
+	raw := strconv.FormatInt(cursor.Created.UnixNano(), 10) + ":" + cursor.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageToken(token string) (*model.OrderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	created, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("malformed page token")
+	}
+
+	nanos, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	orderID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &model.OrderCursor{Created: time.Unix(0, nanos).UTC(), ID: orderID}, nil
+}
+
+type OrdersResponse struct {
+	Orders        []OrderResponse `json:"orders"`
+	NextPageToken string          `json:"next_page_token,omitempty"`
+}
+
+func (a *API) ordersFromPage(page model.OrderPage) OrdersResponse {
+	orders := make([]OrderResponse, 0, len(page.Orders))
+	for _, order := range page.Orders {
+		orders = append(orders, a.orderFromModel(order))
+	}
+
+	return OrdersResponse{
+		Orders:        orders,
+		NextPageToken: encodePageToken(page.Next),
+	}
+}
+
 type deliveryResponse struct {
 	Name    string `json:"name"`
 	Phone   string `json:"phone"`
@@ -81,6 +540,7 @@ type paymentResponse struct {
 	DeliveryCost int64     `json:"delivery_cost"`
 	GoodsTotal   int64     `json:"goods_total"`
 	CustomFee    int64     `json:"custom_fee"`
+	Refund       int64     `json:"refund"`
 }
 
 type itemResponse struct {
@@ -95,6 +555,7 @@ type itemResponse struct {
 	NmID        uuid.UUID `json:"nm_id"`
 	Brand       string    `json:"brand"`
 	Status      string    `json:"status"`
+	Version     int64     `json:"version"`
 }
 
 type OrderResponse struct {
@@ -110,6 +571,7 @@ type OrderResponse struct {
 	DeliveryService   string           `json:"delivery_service"`
 	SmID              int64            `json:"sm_id"`
 	DateCreated       time.Time        `json:"date_created"`
+	History           []statusChange   `json:"history,omitempty"`
 }
 
 func (a *API) orderFromModel(order model.Order) OrderResponse {
@@ -153,6 +615,7 @@ func (a *API) paymentFromModel(payment model.Payment) paymentResponse {
 		DeliveryCost: payment.DeliveryCost,
 		GoodsTotal:   payment.GoodsTotal,
 		CustomFee:    payment.CustomFee,
+		Refund:       payment.Refund,
 	}
 }
 
@@ -177,5 +640,49 @@ func (a *API) itemFromModel(orderItem model.OrderItem) itemResponse {
 		NmID:       orderItem.Item.ID,
 		Brand:      orderItem.Item.Brand,
 		Status:     string(orderItem.Status),
+		Version:    orderItem.Version,
 	}
 }
+
+type HistoryResponse struct {
+	OrderID uuid.UUID      `json:"order_id"`
+	History []statusChange `json:"history"`
+}
+
+type statusChange struct {
+	Rid            uuid.UUID       `json:"rid"`
+	PreviousStatus string          `json:"previous_status,omitempty"`
+	Status         string          `json:"status"`
+	Source         *sourceResponse `json:"source,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+	ChangedAt      time.Time       `json:"changed_at"`
+}
+
+type sourceResponse struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+func (a *API) historyFromModels(history []model.StatusChange) []statusChange {
+	r := make([]statusChange, 0, len(history))
+	for _, change := range history {
+		resp := statusChange{
+			Rid:            change.ItemID,
+			PreviousStatus: string(change.From),
+			Status:         string(change.To),
+			Reason:         change.Reason,
+			ChangedAt:      change.Changed,
+		}
+		if change.Source.Topic != "" {
+			resp.Source = &sourceResponse{
+				Topic:     change.Source.Topic,
+				Partition: change.Source.Partition,
+				Offset:    change.Source.Offset,
+			}
+		}
+		r = append(r, resp)
+	}
+
+	return r
+}
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"order_service/internal/api"
 	"order_service/internal/model"
@@ -18,12 +22,11 @@ import (
 
 func TestAPI_Order(t *testing.T) {
 	s := mockapi.NewService(t)
-	ctx := context.Background()
 	a := api.New(s)
 
 	testOrder := createTestOrder()
 
-	s.EXPECT().Order(ctx, testOrder.ID).
+	s.EXPECT().Order(mock.Anything, testOrder.ID).
 		Return(testOrder, nil).Once()
 
 	req := httptest.NewRequest(http.MethodGet, "/order/"+testOrder.ID.String(), nil)
@@ -67,6 +70,552 @@ func TestAPI_Order_EmptyID(t *testing.T) {
 	require.Contains(t, echo.Map{"reason": "invalid request format or params"}, resp)
 }
 
+func TestAPI_Order_WithHistory(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	testOrder := createTestOrder()
+	history := []model.StatusChange{{
+		ItemID:  testOrder.Items[0].ID,
+		OrderID: testOrder.ID,
+		To:      model.Pending,
+		Source:  model.Source{Topic: "wb-orders", Partition: 0, Offset: 3},
+		Changed: time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+	}}
+
+	s.EXPECT().Order(mock.Anything, testOrder.ID).Return(testOrder, nil).Once()
+	s.EXPECT().History(mock.Anything, testOrder.ID).Return(history, nil).Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/order/"+testOrder.ID.String()+"?include=history", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp api.OrderResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.History, 1)
+	require.Equal(t, "pending", resp.History[0].Status)
+	require.Equal(t, "wb-orders", resp.History[0].Source.Topic)
+}
+
+func TestAPI_History(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	orderID, rid := uuid.New(), uuid.New()
+	changed := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+
+	s.EXPECT().History(mock.Anything, orderID).Return([]model.StatusChange{
+		{ItemID: rid, OrderID: orderID, To: model.Pending, Changed: changed},
+		{ItemID: rid, OrderID: orderID, From: model.Pending, To: model.Processing, Changed: changed.Add(time.Hour)},
+	}, nil).Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/order/"+orderID.String()+"/history", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp api.HistoryResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, orderID, resp.OrderID)
+	require.Len(t, resp.History, 2)
+	require.Equal(t, "pending", resp.History[1].PreviousStatus)
+	require.Equal(t, "processing", resp.History[1].Status)
+	require.Nil(t, resp.History[1].Source)
+}
+
+func TestAPI_History_NotFound(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	orderID := uuid.New()
+	s.EXPECT().History(mock.Anything, orderID).Return(nil, model.ErrOrderNotFound).Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/order/"+orderID.String()+"/history", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPI_Orders(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	testOrder := createTestOrder()
+	customerID := uuid.New()
+	next := &model.OrderCursor{Created: time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC), ID: testOrder.ID}
+
+	s.EXPECT().Orders(mock.Anything, model.OrderFilter{
+		CustomerID:      customerID,
+		DeliveryService: "meest",
+		ItemStatus:      model.Pending,
+		CreatedFrom:     time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC),
+		Limit:           1,
+	}).Return(model.OrderPage{Orders: []model.Order{testOrder}, Next: next}, nil).Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/orders?customer_id="+customerID.String()+
+		"&delivery_service=meest&status=pending&created_from=2021-11-01T00:00:00Z&limit=1", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp api.OrdersResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Orders, 1)
+	require.Equal(t, testOrder.ID, resp.Orders[0].ID)
+	require.NotEmpty(t, resp.NextPageToken)
+
+	s.EXPECT().Orders(mock.Anything, model.OrderFilter{After: next}).
+		Return(model.OrderPage{Orders: []model.Order{}}, nil).Once()
+
+	req = httptest.NewRequest(http.MethodGet, "/orders?page_token="+resp.NextPageToken, nil)
+	rec = httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	resp = api.OrdersResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Empty(t, resp.Orders)
+	require.Empty(t, resp.NextPageToken)
+}
+
+func TestAPI_Orders_InvalidParams(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	for _, query := range []string{
+		"customer_id=invalid-uuid",
+		"created_from=yesterday",
+		"created_to=2021-13-01",
+		"limit=-1",
+		"page_token=!!!",
+		"status=lost",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/orders?"+query, nil)
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusBadRequest, rec.Code, query)
+
+		var resp echo.Map
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, echo.Map{"reason": "invalid request format or params"}, resp)
+	}
+}
+
+const createOrderBody = `{
+	"order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d",
+	"customer_id": "6c1a5b80-6dd9-4b5a-8c3f-9e0c2e8fd7a1",
+	"items": [{"chrt_id": 9934930, "rid": "ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3", "status": 100}]
+}`
+
+func postOrder(a *api.API, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(api.IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAPI_CreateOrder(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	orderID := uuid.MustParse("b563feb7-b2b8-4b6e-9146-d646fd26fb0d")
+	stored := model.Order{ID: orderID}
+
+	s.EXPECT().ProcessOrder(mock.Anything, mock.MatchedBy(func(o model.Order) bool {
+		return o.ID == orderID && len(o.Items) == 1 && o.Items[0].Status == model.Pending
+	})).Return(nil).Once()
+	s.EXPECT().Order(mock.Anything, orderID).Return(stored, nil).Once()
+
+	rec := postOrder(a, "", createOrderBody)
+
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var resp api.OrderResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, orderID, resp.ID)
+}
+
+func TestAPI_CreateOrder_Invalid(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	rec := postOrder(a, "", `{"items": []}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = postOrder(a, "", `{not json`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAPI_CreateOrder_Idempotent(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	orderID := uuid.MustParse("b563feb7-b2b8-4b6e-9146-d646fd26fb0d")
+	var saved model.IdempotentResponse
+
+	s.EXPECT().ReserveIdempotencyKey(mock.Anything, "key-1", mock.Anything).
+		Return(model.IdempotentResponse{}, true, nil).Once()
+	s.EXPECT().ProcessOrder(mock.Anything, mock.Anything).Return(nil).Once()
+	s.EXPECT().Order(mock.Anything, orderID).Return(model.Order{ID: orderID}, nil).Once()
+	s.EXPECT().SaveIdempotentResponse(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, resp model.IdempotentResponse) error {
+			saved = resp
+			return nil
+		}).Once()
+
+	first := postOrder(a, "key-1", createOrderBody)
+
+	require.Equal(t, http.StatusCreated, first.Code)
+	require.Empty(t, first.Header().Get(api.IdempotentReplayedHeader))
+	require.Equal(t, "key-1", saved.Key)
+	require.Equal(t, http.StatusCreated, saved.StatusCode)
+
+	// The retry is answered from the stored response without processing.
+	s.EXPECT().ReserveIdempotencyKey(mock.Anything, "key-1", mock.Anything).Return(saved, false, nil).Twice()
+
+	retry := postOrder(a, "key-1", createOrderBody)
+
+	require.Equal(t, http.StatusCreated, retry.Code)
+	require.Equal(t, "true", retry.Header().Get(api.IdempotentReplayedHeader))
+	require.JSONEq(t, first.Body.String(), retry.Body.String())
+
+	other := postOrder(a, "key-1", strings.Replace(createOrderBody, "9934930", "1", 1))
+
+	require.Equal(t, http.StatusUnprocessableEntity, other.Code)
+}
+
+func TestAPI_CreateOrder_IdempotentPending(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	// A duplicate arriving while the first request is processed is not
+	// processed again.
+	s.EXPECT().ReserveIdempotencyKey(mock.Anything, "key-1", mock.Anything).
+		RunAndReturn(func(_ context.Context, key, hash string) (model.IdempotentResponse, bool, error) {
+			return model.IdempotentResponse{Key: key, RequestHash: hash}, false, nil
+		}).Once()
+
+	rec := postOrder(a, "key-1", createOrderBody)
+
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestAPI_CreateOrder_ServerErrorNotStored(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	var hash string
+	s.EXPECT().ReserveIdempotencyKey(mock.Anything, "key-1", mock.Anything).
+		RunAndReturn(func(_ context.Context, _, requestHash string) (model.IdempotentResponse, bool, error) {
+			hash = requestHash
+			return model.IdempotentResponse{}, true, nil
+		}).Once()
+	s.EXPECT().ProcessOrder(mock.Anything, mock.Anything).Return(errors.New("db is down")).Once()
+	s.EXPECT().ReleaseIdempotencyKey(mock.Anything, "key-1", mock.Anything).
+		RunAndReturn(func(_ context.Context, _, requestHash string) error {
+			require.Equal(t, hash, requestHash)
+			return nil
+		}).Once()
+
+	rec := postOrder(a, "key-1", createOrderBody)
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func patchItem(a *api.API, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAPI_UpdateItem(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	order := createTestOrder()
+	item := order.Items[0]
+	path := "/order/" + order.ID.String() + "/items/" + item.ID.String()
+
+	updated := order
+	updated.Items = []model.OrderItem{item}
+	updated.Items[0].Status = model.Assembling
+	updated.Items[0].Version = 4
+
+	s.EXPECT().UpdateItemStatus(mock.Anything, order.ID, item.ID, model.Assembling, "", int64(3)).
+		Return(updated, nil).Once()
+
+	rec := patchItem(a, path, `"3"`, `{"status": "assembling"}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"4"`, rec.Header().Get("ETag"))
+
+	var resp api.OrderResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "assembling", resp.Items[0].Status)
+	require.Equal(t, int64(4), resp.Items[0].Version)
+
+	// Without If-Match the version is taken from the body.
+	s.EXPECT().UpdateItemStatus(mock.Anything, order.ID, item.ID, model.Assembling, "", int64(3)).
+		Return(updated, nil).Once()
+
+	rec = patchItem(a, path, "", `{"status": "assembling", "version": 3}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAPI_UpdateItem_InvalidRequest(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	path := "/order/" + uuid.NewString() + "/items/" + uuid.NewString()
+
+	tests := map[string]struct {
+		path     string
+		ifMatch  string
+		body     string
+		expected int
+	}{
+		"invalid rid": {
+			path:     "/order/" + uuid.NewString() + "/items/invalid",
+			ifMatch:  `"1"`,
+			body:     `{"status": "assembling"}`,
+			expected: http.StatusBadRequest,
+		},
+		"unknown status": {
+			path:     path,
+			ifMatch:  `"1"`,
+			body:     `{"status": "lost"}`,
+			expected: http.StatusBadRequest,
+		},
+		"weak etag": {
+			path:     path,
+			ifMatch:  `W/"1"`,
+			body:     `{"status": "assembling"}`,
+			expected: http.StatusBadRequest,
+		},
+		"no version": {
+			path:     path,
+			body:     `{"status": "assembling"}`,
+			expected: http.StatusPreconditionRequired,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := patchItem(a, tt.path, tt.ifMatch, tt.body)
+			require.Equal(t, tt.expected, rec.Code)
+		})
+	}
+}
+
+func TestAPI_UpdateItem_ServiceErrors(t *testing.T) {
+	orderID, rid := uuid.New(), uuid.New()
+	path := "/order/" + orderID.String() + "/items/" + rid.String()
+
+	tests := map[string]struct {
+		err      error
+		expected int
+	}{
+		"reason required":    {err: model.ErrReasonRequired, expected: http.StatusBadRequest},
+		"order not found":    {err: model.ErrOrderNotFound, expected: http.StatusNotFound},
+		"item not found":     {err: model.ErrItemNotFound, expected: http.StatusNotFound},
+		"version conflict":   {err: model.ErrVersionConflict, expected: http.StatusPreconditionFailed},
+		"invalid transition": {err: model.ErrInvalidTransition, expected: http.StatusConflict},
+		"internal error":     {err: errors.New("db is down"), expected: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := mockapi.NewService(t)
+			a := api.New(s)
+
+			// "*" skips the version check.
+			s.EXPECT().UpdateItemStatus(mock.Anything, orderID, rid, model.InTransit, "", int64(0)).
+				Return(model.Order{}, errors.WithStack(tt.err)).Once()
+
+			rec := patchItem(a, path, "*", `{"status": "in_transit"}`)
+
+			require.Equal(t, tt.expected, rec.Code)
+		})
+	}
+}
+
+func postJSON(a *api.API, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAPI_CancelOrder(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	order := createTestOrder()
+	order.Items[0].Status = model.Cancelled
+	order.Payment.Refund = 1817
+
+	s.EXPECT().CancelOrder(mock.Anything, order.ID, "changed my mind").Return(order, nil).Once()
+
+	rec := postJSON(a, "/order/"+order.ID.String()+"/cancel", `{"reason": "changed my mind"}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp api.OrderResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "cancelled", resp.Items[0].Status)
+	require.Equal(t, int64(1817), resp.Payment.Refund)
+}
+
+func TestAPI_ReturnItems(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	order := createTestOrder()
+	rid := order.Items[0].ID
+
+	s.EXPECT().ReturnItems(mock.Anything, order.ID, []uuid.UUID{rid}, "wrong size").Return(order, nil).Once()
+
+	rec := postJSON(a, "/order/"+order.ID.String()+"/returns",
+		`{"items": ["`+rid.String()+`"], "reason": "wrong size"}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = postJSON(a, "/order/"+order.ID.String()+"/returns", `{"items": ["not-a-uuid"]}`)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAPI_CancelOrder_ServiceErrors(t *testing.T) {
+	orderID := uuid.New()
+
+	tests := map[string]struct {
+		err      error
+		expected int
+	}{
+		"no reason":         {err: model.ErrReasonRequired, expected: http.StatusBadRequest},
+		"order not found":   {err: model.ErrOrderNotFound, expected: http.StatusNotFound},
+		"already shipped":   {err: model.ErrInvalidTransition, expected: http.StatusConflict},
+		"concurrent change": {err: model.ErrVersionConflict, expected: http.StatusPreconditionFailed},
+		"internal error":    {err: errors.New("db is down"), expected: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := mockapi.NewService(t)
+			a := api.New(s)
+
+			s.EXPECT().CancelOrder(mock.Anything, orderID, "").
+				Return(model.Order{}, errors.WithStack(tt.err)).Once()
+
+			rec := postJSON(a, "/order/"+orderID.String()+"/cancel", `{}`)
+
+			require.Equal(t, tt.expected, rec.Code)
+		})
+	}
+}
+
+func TestAPI_Healthz(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s, api.ReadinessCheck{
+		Name: "postgres",
+		Check: func(ctx context.Context) error {
+			return errors.New("connection refused")
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp api.HealthResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "ok", resp.Status)
+}
+
+func TestAPI_Readyz(t *testing.T) {
+	s := mockapi.NewService(t)
+	ready := func(ctx context.Context) error { return nil }
+	a := api.New(s,
+		api.ReadinessCheck{Name: "postgres", Check: ready},
+		api.ReadinessCheck{Name: "cache", Check: ready},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp api.HealthResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "ok", resp.Status)
+	require.Len(t, resp.Components, 2)
+	require.Equal(t, "postgres", resp.Components[0].Name)
+	require.Equal(t, "ok", resp.Components[0].Status)
+	require.NotEmpty(t, resp.Components[0].Latency)
+}
+
+func TestAPI_Readyz_Unavailable(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s,
+		api.ReadinessCheck{Name: "postgres", Check: func(ctx context.Context) error { return nil }},
+		api.ReadinessCheck{Name: "kafka", Check: func(ctx context.Context) error {
+			return errors.New("kafka consumer has no active group session")
+		}},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var resp api.HealthResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "unavailable", resp.Status)
+	require.Equal(t, "ok", resp.Components[0].Status)
+	require.Equal(t, "unavailable", resp.Components[1].Status)
+	require.Equal(t, "kafka consumer has no active group session", resp.Components[1].Error)
+}
+
+func TestAPI_Metrics(t *testing.T) {
+	s := mockapi.NewService(t)
+	a := api.New(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/order/invalid-uuid", nil)
+	a.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(),
+		`order_service_http_request_duration_seconds_count{method="GET",route="/order/:id",status="400"}`)
+}
+
 func createTestOrder() model.Order {
 	return model.Order{
 		ID:                uuid.New(),
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck reports whether a dependency is ready to serve traffic.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type componentStatus struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status     string            `json:"status"`
+	Components []componentStatus `json:"components,omitempty"`
+}
+
+func (a *API) healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+func (a *API) readyz(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+
+	components := make([]componentStatus, len(a.checks))
+
+	var wg sync.WaitGroup
+	for i, check := range a.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check.Check(ctx)
+
+			components[i] = componentStatus{
+				Name:    check.Name,
+				Status:  "ok",
+				Latency: time.Since(start).String(),
+			}
+			if err != nil {
+				components[i].Status = "unavailable"
+				components[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	resp := HealthResponse{Status: "ok", Components: components}
+	for _, component := range components {
+		if component.Status != "ok" {
+			resp.Status = "unavailable"
+			return c.JSON(http.StatusServiceUnavailable, resp)
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
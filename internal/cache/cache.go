@@ -2,77 +2,401 @@ package cache
 
 import (
 	"container/list"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"order_service/internal/metrics"
+)
+
+// EvictionReason tells why an entry left the cache.
+type EvictionReason int
+
+const (
+	// Evicted entries were the least recently used when capacity was reached.
+	Evicted EvictionReason = iota
+	// Expired entries outlived their TTL.
+	Expired
+	// Deleted entries were removed explicitly.
+	Deleted
 )
 
-type Item struct {
-	key       string
-	value     interface{}
-	timestamp time.Time
+func (r EvictionReason) String() string {
+	switch r {
+	case Evicted:
+		return "evicted"
+	case Expired:
+		return "expired"
+	case Deleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictionCallback is called after an entry has been removed from the cache,
+// outside of the cache lock.
+type EvictionCallback[K comparable, V any] func(key K, value V, reason EvictionReason)
+
+type Option func(*options)
+
+type options struct {
+	name            string
+	onEvict         any
+	janitorInterval time.Duration
+	staleGrace      time.Duration
+}
+
+// DefaultName labels the metrics of caches created without WithName.
+const DefaultName = "default"
+
+// WithName labels the cache's metrics with name, so that the hit ratios of
+// different caches in a process are reported apart.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithEvictionCallback registers fn to be notified of every removed entry.
+// Its key and value types must match the cache's.
+func WithEvictionCallback[K comparable, V any](fn EvictionCallback[K, V]) Option {
+	return func(o *options) {
+		o.onEvict = fn
+	}
+}
+
+// WithJanitor starts a background goroutine removing expired entries every
+// interval. It runs until Stop is called.
+func WithJanitor(interval time.Duration) Option {
+	return func(o *options) {
+		o.janitorInterval = interval
+	}
+}
+
+// WithStaleGrace keeps expired entries for grace longer so that GetStale
+// can still serve them while a fresh value is being loaded. Get treats such
+// entries as missing.
+func WithStaleGrace(grace time.Duration) Option {
+	return func(o *options) {
+		o.staleGrace = grace
+	}
+}
+
+func applyOptions[K comparable, V any](opts []Option) (options, EvictionCallback[K, V]) {
+	o := options{name: DefaultName}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.onEvict == nil {
+		return o, nil
+	}
+
+	onEvict, ok := o.onEvict.(EvictionCallback[K, V])
+	if !ok {
+		panic(fmt.Sprintf("cache: eviction callback %T does not match cache types", o.onEvict))
+	}
+
+	return o, onEvict
 }
 
-type LRUCache struct {
+// Stats are cumulative counters of a cache.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+type stats struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+
+	hitsTotal        prometheus.Counter
+	missesTotal      prometheus.Counter
+	evictionsTotal   prometheus.Counter
+	expirationsTotal prometheus.Counter
+}
+
+func (s *stats) init(name string) {
+	s.hitsTotal = metrics.CacheHits.WithLabelValues(name)
+	s.missesTotal = metrics.CacheMisses.WithLabelValues(name)
+	s.evictionsTotal = metrics.CacheEvictions.WithLabelValues(name)
+	s.expirationsTotal = metrics.CacheExpirations.WithLabelValues(name)
+}
+
+func (s *stats) hit() {
+	s.hits.Add(1)
+	s.hitsTotal.Inc()
+}
+
+func (s *stats) miss() {
+	s.misses.Add(1)
+	s.missesTotal.Inc()
+}
+
+func (s *stats) evicted() {
+	s.evictions.Add(1)
+	s.evictionsTotal.Inc()
+}
+
+func (s *stats) expired(n int) {
+	s.expirations.Add(uint64(n))
+	s.expirationsTotal.Add(float64(n))
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Hits:        s.hits.Load(),
+		Misses:      s.misses.Load(),
+		Evictions:   s.evictions.Load(),
+		Expirations: s.expirations.Load(),
+	}
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// dead reports whether the entry is past its stale grace window as well.
+func (e *entry[K, V]) dead(now time.Time, grace time.Duration) bool {
+	return !e.expires.IsZero() && now.After(e.expires.Add(grace))
+}
+
+type eviction[K comparable, V any] struct {
+	entry  *entry[K, V]
+	reason EvictionReason
+}
+
+// LRU is a least recently used cache with per-entry expiry. It is safe for
+// concurrent use.
+type LRU[K comparable, V any] struct {
 	capacity uint64
-	items    map[string]*list.Element
+	items    map[K]*list.Element
 	queue    *list.List
-	mu       sync.RWMutex
+	mu       sync.Mutex
 	ttl      time.Duration
+	grace    time.Duration
+	onEvict  EvictionCallback[K, V]
+	janitor  *janitor
+	stats    stats
 }
 
-func New(capacity uint64, ttl time.Duration) *LRUCache {
-	return &LRUCache{
+// New creates a cache holding up to capacity entries, each living for ttl
+// after its last write. A non-positive ttl disables expiry.
+func New[K comparable, V any](capacity uint64, ttl time.Duration, opts ...Option) *LRU[K, V] {
+	o, onEvict := applyOptions[K, V](opts)
+
+	c := &LRU[K, V]{
 		capacity: capacity,
-		items:    make(map[string]*list.Element),
+		items:    make(map[K]*list.Element),
 		queue:    list.New(),
 		ttl:      ttl,
+		grace:    o.staleGrace,
+		onEvict:  onEvict,
+	}
+	c.stats.init(o.name)
+	c.janitor = startJanitor(o.janitorInterval, c.DeleteExpired)
+
+	return c
+}
+
+// Set stores value under key with the default TTL.
+func (c *LRU[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores value under key, expiring it ttl from now. Overwriting an
+// existing key refreshes its expiry.
+func (c *LRU[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
 	}
+
+	c.set(key, value, expires)
 }
 
-func (c *LRUCache) Set(key string, value interface{}) {
+func (c *LRU[K, V]) set(key K, value V, expires time.Time) {
+	c.mu.Lock()
+
 	if element, exists := c.items[key]; exists {
 		c.queue.MoveToFront(element)
-		element.Value.(*Item).value = value
+		e := element.Value.(*entry[K, V])
+		e.value = value
+		e.expires = expires
+		c.mu.Unlock()
 		return
 	}
 
+	var evicted []eviction[K, V]
 	if c.queue.Len() == int(c.capacity) {
 		element := c.queue.Back()
 		if element != nil {
-			item := c.queue.Remove(element).(*Item)
-			delete(c.items, item.key)
+			evicted = append(evicted, eviction[K, V]{entry: c.remove(element), reason: Evicted})
+			c.stats.evicted()
 		}
 	}
 
-	item := &Item{
-		key:       key,
-		value:     value,
-		timestamp: time.Now(),
+	e := &entry[K, V]{
+		key:     key,
+		value:   value,
+		expires: expires,
 	}
 
-	element := c.queue.PushFront(item)
-	c.items[item.key] = element
+	element := c.queue.PushFront(e)
+	c.items[e.key] = element
 
-	return
+	c.mu.Unlock()
+	c.notify(evicted)
 }
 
-func (c *LRUCache) Get(key string) interface{} {
+// Get returns the value stored under key if it has not expired.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	value, ok, fresh := c.GetStale(key)
+	if !ok || !fresh {
+		var zero V
+		return zero, false
+	}
+
+	return value, true
+}
+
+// GetStale is like Get but also returns entries that have expired less than
+// the stale grace ago, reporting them as not fresh.
+func (c *LRU[K, V]) GetStale(key K) (value V, ok bool, fresh bool) {
+	now := time.Now()
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
 	element, exists := c.items[key]
 	if !exists {
-		return nil
+		c.mu.Unlock()
+		c.stats.miss()
+		return value, false, false
 	}
 
-	item := element.Value.(*Item)
+	e := element.Value.(*entry[K, V])
 
-	if time.Since(item.timestamp) > c.ttl {
-		c.queue.Remove(element)
-		delete(c.items, item.key)
-		return nil
+	if e.dead(now, c.grace) {
+		c.remove(element)
+		c.mu.Unlock()
+
+		c.stats.expired(1)
+		c.stats.miss()
+		c.notify([]eviction[K, V]{{entry: e, reason: Expired}})
+		return value, false, false
 	}
 
 	c.queue.MoveToFront(element)
-	return item.value
+	value, fresh = e.value, !e.expired(now)
+	c.mu.Unlock()
+
+	if !fresh {
+		c.stats.miss()
+		return value, true, false
+	}
+
+	c.stats.hit()
+	return value, true, true
+}
+
+// Delete removes key from the cache.
+func (c *LRU[K, V]) Delete(key K) {
+	c.mu.Lock()
+	element, exists := c.items[key]
+	if !exists {
+		c.mu.Unlock()
+		return
+	}
+	e := c.remove(element)
+	c.mu.Unlock()
+
+	c.notify([]eviction[K, V]{{entry: e, reason: Deleted}})
+}
+
+// Len returns the number of entries, including expired ones not yet removed.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.queue.Len()
+}
+
+// Keys returns the keys from the most to the least recently used.
+func (c *LRU[K, V]) Keys() []K {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]K, 0, c.queue.Len())
+	for element := c.queue.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*entry[K, V]).key)
+	}
+
+	return keys
+}
+
+// Purge removes all entries without notifying the eviction callback.
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[K]*list.Element)
+	c.queue.Init()
+}
+
+// Stats returns the cache counters.
+func (c *LRU[K, V]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// DeleteExpired removes every entry whose TTL and stale grace have elapsed.
+func (c *LRU[K, V]) DeleteExpired() {
+	now := time.Now()
+
+	c.mu.Lock()
+	var expired []eviction[K, V]
+	for element := c.queue.Back(); element != nil; {
+		prev := element.Prev()
+		if e := element.Value.(*entry[K, V]); e.dead(now, c.grace) {
+			expired = append(expired, eviction[K, V]{entry: c.remove(element), reason: Expired})
+		}
+		element = prev
+	}
+	c.mu.Unlock()
+
+	c.stats.expired(len(expired))
+	c.notify(expired)
+}
+
+// Stop terminates the janitor, if any. The cache stays usable.
+func (c *LRU[K, V]) Stop() {
+	c.janitor.stop()
+}
+
+func (c *LRU[K, V]) remove(element *list.Element) *entry[K, V] {
+	e := c.queue.Remove(element).(*entry[K, V])
+	delete(c.items, e.key)
+	return e
+}
+
+func (c *LRU[K, V]) notify(evicted []eviction[K, V]) {
+	if c.onEvict == nil {
+		return
+	}
+
+	for _, e := range evicted {
+		c.onEvict(e.entry.key, e.entry.value, e.reason)
+	}
 }
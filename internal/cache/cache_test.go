@@ -0,0 +1,523 @@
+package cache_test
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/cache"
+	"order_service/internal/metrics"
+)
+
+type store interface {
+	cache.Store[string, int]
+	Len() int
+	Keys() []string
+	Stats() cache.Stats
+}
+
+type stoppableStore interface {
+	store
+	Stop()
+}
+
+func requireValue[V any](t *testing.T, expected V, value V, ok bool) {
+	t.Helper()
+	require.True(t, ok)
+	require.Equal(t, expected, value)
+}
+
+func requireMissing[V any](t *testing.T, _ V, ok bool) {
+	t.Helper()
+	require.False(t, ok)
+}
+
+func TestLRU_Eviction(t *testing.T) {
+	c := cache.New[string, int](2, time.Minute)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	value, ok := c.Get("a")
+	requireValue(t, 1, value, ok)
+
+	c.Set("c", 3)
+
+	value, ok = c.Get("b")
+	requireMissing(t, value, ok)
+	value, ok = c.Get("a")
+	requireValue(t, 1, value, ok)
+	value, ok = c.Get("c")
+	requireValue(t, 3, value, ok)
+}
+
+func TestLRU_Expiration(t *testing.T) {
+	c := cache.New[string, int](2, time.Millisecond)
+
+	c.Set("a", 1)
+	time.Sleep(5 * time.Millisecond)
+
+	value, ok := c.Get("a")
+	requireMissing(t, value, ok)
+}
+
+func TestLRU_ZeroValue(t *testing.T) {
+	c := cache.New[string, int](2, time.Minute)
+
+	c.Set("zero", 0)
+
+	value, ok := c.Get("zero")
+	requireValue(t, 0, value, ok)
+}
+
+func TestLRU_LenKeys(t *testing.T) {
+	c := cache.New[int, string](3, time.Minute)
+
+	c.Set(1, "a")
+	c.Set(2, "b")
+	c.Set(3, "c")
+	c.Get(1)
+
+	require.Equal(t, 3, c.Len())
+	require.Equal(t, []int{1, 3, 2}, c.Keys())
+
+	c.Delete(3)
+	require.Equal(t, 2, c.Len())
+	require.Equal(t, []int{1, 2}, c.Keys())
+}
+
+func TestLRU_Stats(t *testing.T) {
+	c := cache.New[string, int](1, 5*time.Millisecond)
+
+	c.Set("a", 1)
+	c.Get("a")
+	c.Get("missing")
+	c.Set("b", 2)
+	time.Sleep(10 * time.Millisecond)
+	c.DeleteExpired()
+
+	require.Equal(t, cache.Stats{Hits: 1, Misses: 1, Evictions: 1, Expirations: 1}, c.Stats())
+}
+
+func TestLRU_MetricsByName(t *testing.T) {
+	named := cache.New[string, int](1, time.Minute, cache.WithName("lru-metrics-test"))
+	unnamed := cache.New[string, int](1, time.Minute)
+
+	hits := testutil.ToFloat64(metrics.CacheHits.WithLabelValues(cache.DefaultName))
+
+	named.Set("a", 1)
+	named.Get("a")
+	named.Get("missing")
+	unnamed.Get("missing")
+
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.CacheHits.WithLabelValues("lru-metrics-test")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.CacheMisses.WithLabelValues("lru-metrics-test")))
+	require.Equal(t, hits, testutil.ToFloat64(metrics.CacheHits.WithLabelValues(cache.DefaultName)))
+}
+
+func TestLRU_EvictionCallbackTypeMismatch(t *testing.T) {
+	require.Panics(t, func() {
+		cache.New[string, int](1, time.Minute, cache.WithEvictionCallback(func(string, string, cache.EvictionReason) {}))
+	})
+}
+
+func TestSharded(t *testing.T) {
+	c := cache.NewSharded[string, string](8, 64, time.Minute)
+
+	for i := 0; i < 64; i++ {
+		c.Set(strconv.Itoa(i), strconv.Itoa(i))
+	}
+
+	found := 0
+	for i := 0; i < 64; i++ {
+		if value, ok := c.Get(strconv.Itoa(i)); ok {
+			require.Equal(t, strconv.Itoa(i), value)
+			found++
+		}
+	}
+	require.Positive(t, found)
+	require.Equal(t, found, c.Len())
+	require.Len(t, c.Keys(), found)
+
+	c.Set("1", "updated")
+	value, ok := c.Get("1")
+	requireValue(t, "updated", value, ok)
+}
+
+func TestSharded_Capacity(t *testing.T) {
+	c := cache.NewSharded[int, int](4, 8, time.Minute)
+
+	for i := 0; i < 1000; i++ {
+		c.Set(i, i)
+	}
+
+	found := 0
+	for i := 0; i < 1000; i++ {
+		if _, ok := c.Get(i); ok {
+			found++
+		}
+	}
+	require.LessOrEqual(t, found, 8)
+	require.Equal(t, uint64(found), c.Stats().Hits)
+}
+
+// TestCache_Concurrent is meant to be run with -race.
+func TestCache_Concurrent(t *testing.T) {
+	for name, c := range map[string]store{
+		"lru":     cache.New[string, int](100, time.Minute),
+		"sharded": cache.NewSharded[string, int](16, 100, time.Minute),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 1000; i++ {
+						key := strconv.Itoa((w*31 + i) % 200)
+						switch i % 8 {
+						case 0:
+							c.Set(key, i)
+						case 1:
+							c.Keys()
+							c.Len()
+							c.Stats()
+						default:
+							c.Get(key)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// Mixed load: 90% reads, 10% writes over a key space larger than capacity.
+func benchmarkMixed(b *testing.B, c store) {
+	const keys = 20_000
+
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+		c.Set(names[i], i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := names[(i*7919)%keys]
+			if i%10 == 0 {
+				c.Set(key, i)
+			} else {
+				c.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkLRU_Mixed(b *testing.B) {
+	benchmarkMixed(b, cache.New[string, int](10_000, time.Minute))
+}
+
+func BenchmarkSharded_Mixed(b *testing.B) {
+	benchmarkMixed(b, cache.NewSharded[string, int](32, 10_000, time.Minute))
+}
+
+func TestLRU_SetRefreshesExpiry(t *testing.T) {
+	c := cache.New[string, int](2, 50*time.Millisecond)
+
+	c.Set("a", 1)
+	time.Sleep(30 * time.Millisecond)
+	c.Set("a", 2)
+	time.Sleep(30 * time.Millisecond)
+
+	value, ok := c.Get("a")
+	requireValue(t, 2, value, ok)
+}
+
+func TestLRU_SetWithTTL(t *testing.T) {
+	c := cache.New[string, int](2, time.Minute)
+
+	c.SetWithTTL("short", 1, time.Millisecond)
+	c.SetWithTTL("forever", 2, 0)
+	time.Sleep(5 * time.Millisecond)
+
+	value, ok := c.Get("short")
+	requireMissing(t, value, ok)
+	value, ok = c.Get("forever")
+	requireValue(t, 2, value, ok)
+}
+
+func TestLRU_EvictionCallback(t *testing.T) {
+	var mu sync.Mutex
+	reasons := map[string]cache.EvictionReason{}
+
+	c := cache.New[string, int](1, time.Minute, cache.WithEvictionCallback(func(key string, _ int, reason cache.EvictionReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons[key] = reason
+	}))
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.SetWithTTL("b", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	value, ok := c.Get("b")
+	requireMissing(t, value, ok)
+
+	require.Equal(t, map[string]cache.EvictionReason{"a": cache.Evicted, "b": cache.Expired}, reasons)
+}
+
+func TestCache_Janitor(t *testing.T) {
+	for name, newCache := range map[string]func(opts ...cache.Option) stoppableStore{
+		"lru": func(opts ...cache.Option) stoppableStore {
+			return cache.New[string, int](10, 5*time.Millisecond, opts...)
+		},
+		"sharded": func(opts ...cache.Option) stoppableStore {
+			return cache.NewSharded[string, int](4, 10, 5*time.Millisecond, opts...)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expired := make(chan string, 10)
+			c := newCache(
+				cache.WithJanitor(time.Millisecond),
+				cache.WithEvictionCallback(func(key string, _ int, reason cache.EvictionReason) {
+					if reason == cache.Expired {
+						expired <- key
+					}
+				}),
+			)
+			defer c.Stop()
+
+			c.Set("a", 1)
+
+			select {
+			case key := <-expired:
+				require.Equal(t, "a", key)
+			case <-time.After(time.Second):
+				t.Fatal("janitor did not remove the expired entry")
+			}
+
+			c.Stop()
+			c.Stop()
+		})
+	}
+}
+
+func TestTiered(t *testing.T) {
+	local := cache.New[string, int](10, time.Minute)
+	remote := cache.New[string, int](10, time.Minute)
+	c := cache.NewTiered[string, int](local, remote)
+
+	c.Set("a", 1)
+	value, ok := local.Get("a")
+	requireValue(t, 1, value, ok)
+	value, ok = remote.Get("a")
+	requireValue(t, 1, value, ok)
+
+	// Written by another replica: found remotely and promoted to local.
+	remote.Set("b", 2)
+	value, ok = local.Get("b")
+	requireMissing(t, value, ok)
+	value, ok = c.Get("b")
+	requireValue(t, 2, value, ok)
+	value, ok = local.Get("b")
+	requireValue(t, 2, value, ok)
+
+	value, ok = c.Get("c")
+	requireMissing(t, value, ok)
+}
+
+func TestLRU_GetStale(t *testing.T) {
+	c := cache.New[string, int](2, 5*time.Millisecond, cache.WithStaleGrace(time.Minute))
+
+	c.Set("a", 1)
+
+	value, ok, fresh := c.GetStale("a")
+	requireValue(t, 1, value, ok)
+	require.True(t, fresh)
+
+	time.Sleep(10 * time.Millisecond)
+	c.DeleteExpired()
+
+	_, ok = c.Get("a")
+	require.False(t, ok)
+
+	value, ok, fresh = c.GetStale("a")
+	requireValue(t, 1, value, ok)
+	require.False(t, fresh)
+
+	_, ok, fresh = c.GetStale("b")
+	require.False(t, ok)
+	require.False(t, fresh)
+}
+
+func TestTiered_GetStale(t *testing.T) {
+	local := cache.New[string, int](10, 5*time.Millisecond, cache.WithStaleGrace(time.Minute))
+	remote := cache.New[string, int](10, time.Minute)
+	c := cache.NewTiered[string, int](local, remote)
+
+	local.Set("a", 1)
+	time.Sleep(10 * time.Millisecond)
+
+	value, ok, fresh := c.GetStale("a")
+	requireValue(t, 1, value, ok)
+	require.False(t, fresh)
+
+	remote.Set("a", 2)
+
+	value, ok, fresh = c.GetStale("a")
+	requireValue(t, 2, value, ok)
+	require.True(t, fresh)
+	value, ok = local.Get("a")
+	requireValue(t, 2, value, ok)
+}
+
+func TestLRU_Delete(t *testing.T) {
+	var reason cache.EvictionReason
+	c := cache.New[string, int](2, time.Minute, cache.WithEvictionCallback(func(_ string, _ int, r cache.EvictionReason) {
+		reason = r
+	}))
+
+	c.Set("a", 1)
+	c.Delete("a")
+	c.Delete("missing")
+
+	value, ok := c.Get("a")
+	requireMissing(t, value, ok)
+	require.Equal(t, cache.Deleted, reason)
+}
+
+func TestSharded_Purge(t *testing.T) {
+	c := cache.NewSharded[string, int](4, 16, time.Minute)
+
+	for i := 0; i < 8; i++ {
+		c.Set(strconv.Itoa(i), i)
+	}
+	c.Purge()
+
+	require.Zero(t, c.Len())
+	for i := 0; i < 8; i++ {
+		value, ok := c.Get(strconv.Itoa(i))
+		requireMissing(t, value, ok)
+	}
+
+	c.Set("a", 1)
+	value, ok := c.Get("a")
+	requireValue(t, 1, value, ok)
+}
+
+func TestLRU_SaveLoad(t *testing.T) {
+	c := cache.New[string, int](4, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.SetWithTTL("expiring", 3, 5*time.Millisecond)
+	c.Set("c", 4)
+	c.Get("a")
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Save(&buf))
+	time.Sleep(10 * time.Millisecond)
+
+	restored := cache.New[string, int](2, time.Minute)
+	n, err := restored.Load(&buf)
+
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"a", "c"}, restored.Keys())
+	value, ok := restored.Get("a")
+	requireValue(t, 1, value, ok)
+}
+
+func TestLRU_LoadKeepsExpiry(t *testing.T) {
+	c := cache.New[string, int](2, 20*time.Millisecond)
+	c.Set("a", 1)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Save(&buf))
+
+	restored := cache.New[string, int](2, time.Hour)
+	_, err := restored.Load(&buf)
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	value, ok := restored.Get("a")
+	requireMissing(t, value, ok)
+}
+
+func TestLRU_LoadCorrupt(t *testing.T) {
+	c := cache.New[string, int](2, time.Minute)
+
+	for name, data := range map[string]string{
+		"truncated": `{"version":3,"entries":[{"key":"a","value":1`,
+		"version":   `{"version":99,"entries":[{"key":"a","value":1}]}`,
+		"previous":  `{"version":2,"entries":[{"key":"a","value":1}]}`,
+		"type":      `{"version":3,"entries":[{"key":"a","value":"one"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			n, err := c.Load(strings.NewReader(data))
+
+			require.Error(t, err)
+			require.Zero(t, n)
+			require.Zero(t, c.Len())
+		})
+	}
+}
+
+func TestSharded_SaveLoad(t *testing.T) {
+	c := cache.NewSharded[int, string](4, 100, time.Minute)
+	for i := 0; i < 50; i++ {
+		c.Set(i, strconv.Itoa(i))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Save(&buf))
+
+	restored := cache.NewSharded[int, string](8, 100, time.Minute)
+	n, err := restored.Load(&buf)
+
+	require.NoError(t, err)
+	require.Equal(t, 50, n)
+	for i := 0; i < 50; i++ {
+		value, ok := restored.Get(i)
+		requireValue(t, strconv.Itoa(i), value, ok)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	c := cache.New[string, int](2, time.Minute)
+	c.Set("a", 1)
+
+	_, err := cache.NewSnapshotFile(path, c).Restore()
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, cache.NewSnapshotFile(path, c).Save())
+
+	restored := cache.New[string, int](2, time.Minute)
+	n, err := cache.NewSnapshotFile(path, restored).Restore()
+
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	value, ok := restored.Get("a")
+	requireValue(t, 1, value, ok)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = cache.NewSnapshotFile(path, cache.New[string, int](2, time.Minute)).Restore()
+	require.Error(t, err)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// janitor periodically runs a cleanup function in the background.
+type janitor struct {
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// startJanitor runs cleanup every interval. It returns nil for a
+// non-positive interval; stop is safe to call on a nil janitor.
+func startJanitor(interval time.Duration, cleanup func()) *janitor {
+	if interval <= 0 {
+		return nil
+	}
+
+	j := &janitor{done: make(chan struct{})}
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.done:
+				return
+			case <-ticker.C:
+				cleanup()
+			}
+		}
+	}()
+
+	return j
+}
+
+// stop signals the janitor and waits for a running cleanup to finish.
+func (j *janitor) stop() {
+	if j == nil {
+		return
+	}
+
+	j.once.Do(func() { close(j.done) })
+	j.wg.Wait()
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+// Sharded spreads keys over independently locked LRU segments so that
+// concurrent readers and writers of different keys rarely contend. Recency
+// and capacity are tracked per shard, so eviction is approximately LRU.
+type Sharded[K comparable, V any] struct {
+	shards  []*LRU[K, V]
+	seed    maphash.Seed
+	janitor *janitor
+}
+
+// NewSharded accepts the same options as New. A single janitor sweeps all
+// shards.
+func NewSharded[K comparable, V any](shards int, capacity uint64, ttl time.Duration, opts ...Option) *Sharded[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+
+	o, onEvict := applyOptions[K, V](opts)
+
+	perShard := (capacity + uint64(shards) - 1) / uint64(shards)
+	if perShard == 0 {
+		perShard = 1
+	}
+
+	c := &Sharded[K, V]{
+		shards: make([]*LRU[K, V], shards),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range c.shards {
+		shardOpts := []Option{WithName(o.name), WithStaleGrace(o.staleGrace)}
+		if onEvict != nil {
+			shardOpts = append(shardOpts, WithEvictionCallback(onEvict))
+		}
+		c.shards[i] = New[K, V](perShard, ttl, shardOpts...)
+	}
+	c.janitor = startJanitor(o.janitorInterval, c.DeleteExpired)
+
+	return c
+}
+
+func (c *Sharded[K, V]) Set(key K, value V) {
+	c.shard(key).Set(key, value)
+}
+
+func (c *Sharded[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.shard(key).SetWithTTL(key, value, ttl)
+}
+
+func (c *Sharded[K, V]) Get(key K) (V, bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *Sharded[K, V]) GetStale(key K) (V, bool, bool) {
+	return c.shard(key).GetStale(key)
+}
+
+func (c *Sharded[K, V]) Delete(key K) {
+	c.shard(key).Delete(key)
+}
+
+func (c *Sharded[K, V]) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.Len()
+	}
+
+	return n
+}
+
+// Keys returns the keys of all shards. Recency order holds only within a
+// shard.
+func (c *Sharded[K, V]) Keys() []K {
+	var keys []K
+	for _, shard := range c.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+
+	return keys
+}
+
+func (c *Sharded[K, V]) Purge() {
+	for _, shard := range c.shards {
+		shard.Purge()
+	}
+}
+
+func (c *Sharded[K, V]) Stats() Stats {
+	var total Stats
+	for _, shard := range c.shards {
+		s := shard.Stats()
+		total.Hits += s.Hits
+		total.Misses += s.Misses
+		total.Evictions += s.Evictions
+		total.Expirations += s.Expirations
+	}
+
+	return total
+}
+
+func (c *Sharded[K, V]) DeleteExpired() {
+	for _, shard := range c.shards {
+		shard.DeleteExpired()
+	}
+}
+
+func (c *Sharded[K, V]) Stop() {
+	c.janitor.stop()
+}
+
+func (c *Sharded[K, V]) shard(key K) *LRU[K, V] {
+	return c.shards[c.index(key)]
+}
+
+func (c *Sharded[K, V]) index(key K) int {
+	return int(maphash.Comparable(c.seed, key) % uint64(len(c.shards)))
+}
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes, including
+// the fields of cached values such as model.Order; snapshots of other
+// versions are rejected. Version 2 added OrderItem.Version and
+// Order.Revision, version 3 OrderItem.StatusEvent.
+const snapshotVersion = 3
+
+type snapshot[K comparable, V any] struct {
+	Version int                   `json:"version"`
+	Entries []snapshotEntry[K, V] `json:"entries"`
+}
+
+type snapshotEntry[K comparable, V any] struct {
+	Key     K         `json:"key"`
+	Value   V         `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// Snapshotter is a cache that can be written to and restored from a stream.
+type Snapshotter interface {
+	Save(w io.Writer) error
+	Load(r io.Reader) (int, error)
+}
+
+// Save writes the entries that are still usable, most recently used first,
+// together with their expiry.
+func (c *LRU[K, V]) Save(w io.Writer) error {
+	return writeSnapshot(w, c.entries(time.Now()))
+}
+
+// Load adds the entries written by Save, keeping their expiry and recency
+// order and skipping those that have expired since. Nothing is added if the
+// snapshot cannot be decoded. It returns the number of entries added.
+func (c *LRU[K, V]) Load(r io.Reader) (int, error) {
+	entries, err := readSnapshot[K, V](r)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.restore(time.Now(), entries), nil
+}
+
+// Save writes the entries of all shards. Recency order holds only within a
+// shard.
+func (c *Sharded[K, V]) Save(w io.Writer) error {
+	now := time.Now()
+
+	var entries []snapshotEntry[K, V]
+	for _, shard := range c.shards {
+		entries = append(entries, shard.entries(now)...)
+	}
+
+	return writeSnapshot(w, entries)
+}
+
+// Load spreads the entries written by Save over the shards; see LRU.Load.
+func (c *Sharded[K, V]) Load(r io.Reader) (int, error) {
+	entries, err := readSnapshot[K, V](r)
+	if err != nil {
+		return 0, err
+	}
+
+	perShard := make([][]snapshotEntry[K, V], len(c.shards))
+	for _, e := range entries {
+		i := c.index(e.Key)
+		perShard[i] = append(perShard[i], e)
+	}
+
+	now := time.Now()
+	restored := 0
+	for i, shard := range c.shards {
+		restored += shard.restore(now, perShard[i])
+	}
+
+	return restored, nil
+}
+
+// entries returns the entries not past their stale grace, most recently used
+// first.
+func (c *LRU[K, V]) entries(now time.Time) []snapshotEntry[K, V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]snapshotEntry[K, V], 0, c.queue.Len())
+	for element := c.queue.Front(); element != nil; element = element.Next() {
+		e := element.Value.(*entry[K, V])
+		if e.dead(now, c.grace) {
+			continue
+		}
+		entries = append(entries, snapshotEntry[K, V]{Key: e.key, Value: e.value, Expires: e.expires})
+	}
+
+	return entries
+}
+
+// restore inserts entries, given most recently used first, so that they keep
+// their relative order. Only the first capacity live entries are used.
+func (c *LRU[K, V]) restore(now time.Time, entries []snapshotEntry[K, V]) int {
+	live := make([]*entry[K, V], 0, len(entries))
+	for _, s := range entries {
+		if uint64(len(live)) == c.capacity {
+			break
+		}
+		e := &entry[K, V]{key: s.Key, value: s.Value, expires: s.Expires}
+		if e.dead(now, c.grace) {
+			continue
+		}
+		live = append(live, e)
+	}
+
+	for i := len(live) - 1; i >= 0; i-- {
+		c.set(live[i].key, live[i].value, live[i].expires)
+	}
+
+	return len(live)
+}
+
+func writeSnapshot[K comparable, V any](w io.Writer, entries []snapshotEntry[K, V]) error {
+	err := json.NewEncoder(w).Encode(snapshot[K, V]{
+		Version: snapshotVersion,
+		Entries: entries,
+	})
+
+	return errors.WithStack(err)
+}
+
+func readSnapshot[K comparable, V any](r io.Reader) ([]snapshotEntry[K, V], error) {
+	var s snapshot[K, V]
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, errors.Wrap(err, "decode cache snapshot")
+	}
+
+	if s.Version != snapshotVersion {
+		return nil, errors.Newf("unsupported cache snapshot version %d", s.Version)
+	}
+
+	return s.Entries, nil
+}
+
+// SnapshotFile persists a cache to a file so that a restarted process starts
+// with what was hot before instead of an empty cache.
+type SnapshotFile struct {
+	path  string
+	cache Snapshotter
+}
+
+func NewSnapshotFile(path string, cache Snapshotter) *SnapshotFile {
+	return &SnapshotFile{
+		path:  path,
+		cache: cache,
+	}
+}
+
+// Save replaces the file with the current cache contents. The snapshot is
+// written to a temporary file first, so a crash never leaves a truncated one
+// behind.
+func (s *SnapshotFile) Save() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	err = s.cache.Save(tmp)
+	if err == nil {
+		err = errors.WithStack(tmp.Sync())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = errors.WithStack(closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return errors.WithStack(os.Rename(tmp.Name(), s.path))
+}
+
+// Restore loads the file into the cache and returns the number of restored
+// entries. A missing file yields an error matching fs.ErrNotExist.
+func (s *SnapshotFile) Restore() (int, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	return s.cache.Load(f)
+}
@@ -0,0 +1,70 @@
+package cache
+
+// Store is a key-value cache.
+type Store[K comparable, V any] interface {
+	Set(key K, value V)
+	Get(key K) (V, bool)
+}
+
+// StaleStore is a Store that can also serve recently expired entries.
+type StaleStore[K comparable, V any] interface {
+	Store[K, V]
+	GetStale(key K) (value V, ok bool, fresh bool)
+}
+
+// Tiered checks a fast local cache before a shared remote one and fills the
+// local cache from remote hits, so replicas share warm data while hot keys
+// are served from memory.
+type Tiered[K comparable, V any] struct {
+	local  Store[K, V]
+	remote Store[K, V]
+}
+
+func NewTiered[K comparable, V any](local, remote Store[K, V]) *Tiered[K, V] {
+	return &Tiered[K, V]{
+		local:  local,
+		remote: remote,
+	}
+}
+
+// Set writes through to both tiers.
+func (t *Tiered[K, V]) Set(key K, value V) {
+	t.local.Set(key, value)
+	t.remote.Set(key, value)
+}
+
+func (t *Tiered[K, V]) Get(key K) (V, bool) {
+	if value, ok := t.local.Get(key); ok {
+		return value, true
+	}
+
+	value, ok := t.remote.Get(key)
+	if ok {
+		t.local.Set(key, value)
+	}
+
+	return value, ok
+}
+
+// GetStale serves stale entries from the local tier if it supports them,
+// unless the remote tier already has a fresh value.
+func (t *Tiered[K, V]) GetStale(key K) (V, bool, bool) {
+	local, ok := t.local.(StaleStore[K, V])
+	if !ok {
+		value, ok := t.Get(key)
+		return value, ok, ok
+	}
+
+	stale, ok, fresh := local.GetStale(key)
+	if fresh {
+		return stale, true, true
+	}
+
+	// Another replica may already have refreshed the entry.
+	if value, found := t.remote.Get(key); found {
+		t.local.Set(key, value)
+		return value, true, true
+	}
+
+	return stale, ok, false
+}
@@ -8,14 +8,78 @@ import (
 )
 
 type Config struct {
-	Addr        string        `mapstructure:"addr"`
-	DatabaseURL string        `mapstructure:"db_url"`
-	Brokers     []string      `mapstructure:"brokers"`
-	Topics      []string      `mapstructure:"topics"`
-	GroupID     string        `mapstructure:"group_id"`
-	Capacity    uint64        `mapstructure:"capacity"`
-	TTL         time.Duration `mapstructure:"ttl"`
-	Limit       uint64        `mapstructure:"limit"`
+	Addr            string              `mapstructure:"addr"`
+	ShutdownTimeout time.Duration       `mapstructure:"shutdown_timeout"`
+	DatabaseURL     string              `mapstructure:"db_url"`
+	Brokers         []string            `mapstructure:"brokers"`
+	Topics          []string            `mapstructure:"topics"`
+	CommandTopic    string              `mapstructure:"command_topic"`
+	StatusTopic     string              `mapstructure:"status_topic"`
+	GroupID         string              `mapstructure:"group_id"`
+	DLQTopic        string              `mapstructure:"dlq_topic"`
+	Retry           RetryConfig         `mapstructure:"retry"`
+	Outbox          OutboxConfig        `mapstructure:"outbox"`
+	Idempotency     IdempotencyConfig   `mapstructure:"idempotency"`
+	Capacity        uint64              `mapstructure:"capacity"`
+	CacheShards     int                 `mapstructure:"cache_shards"`
+	TTL             time.Duration       `mapstructure:"ttl"`
+	CacheJanitor    time.Duration       `mapstructure:"cache_janitor_interval"`
+	CacheStaleGrace time.Duration       `mapstructure:"cache_stale_grace"`
+	CacheSnapshot   string              `mapstructure:"cache_snapshot"`
+	WarmUp          WarmUpConfig        `mapstructure:"warm_up"`
+	NegativeCache   NegativeCacheConfig `mapstructure:"negative_cache"`
+	RedisURL        string              `mapstructure:"redis_url"`
+	RedisTTL        time.Duration       `mapstructure:"redis_ttl"`
+	Limit           uint64              `mapstructure:"limit"`
+	OTLPEndpoint    string              `mapstructure:"otlp_endpoint"`
+	OTLPInsecure    bool                `mapstructure:"otlp_insecure"`
+}
+
+type WarmUpConfig struct {
+	BatchSize  uint64                 `mapstructure:"batch_size"`
+	Strategies []WarmUpStrategyConfig `mapstructure:"strategies"`
+}
+
+// WarmUpStrategyConfig selects orders to warm the cache with. Name is one of
+// "recent", "active" or "touched"; Window applies to "touched" only.
+type WarmUpStrategyConfig struct {
+	Name   string        `mapstructure:"name"`
+	Limit  uint64        `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+}
+
+type NegativeCacheConfig struct {
+	Capacity uint64        `mapstructure:"capacity"`
+	TTL      time.Duration `mapstructure:"ttl"`
+}
+
+type RetryConfig struct {
+	MaxAttempts          int           `mapstructure:"max_attempts"`
+	UnknownOrderAttempts int           `mapstructure:"unknown_order_attempts"`
+	InitialBackoff       time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff           time.Duration `mapstructure:"max_backoff"`
+}
+
+// OutboxConfig configures publishing of order events; an empty Topic
+// disables the relay, while events are still written to the outbox.
+type OutboxConfig struct {
+	Topic          string        `mapstructure:"topic"`
+	Interval       time.Duration `mapstructure:"interval"`
+	BatchSize      int           `mapstructure:"batch_size"`
+	Lease          time.Duration `mapstructure:"lease"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+// IdempotencyConfig configures Idempotency-Key handling: Lease is how long a
+// key reserved by a request that never completed stays blocked, TTL how long
+// a stored response is replayed, and expired keys are deleted every
+// Interval. Zero values keep the service defaults; a zero Interval disables
+// the deletion.
+type IdempotencyConfig struct {
+	Lease    time.Duration `mapstructure:"lease"`
+	TTL      time.Duration `mapstructure:"ttl"`
+	Interval time.Duration `mapstructure:"interval"`
 }
 
 func Load() (*Config, error) {
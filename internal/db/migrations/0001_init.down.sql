@@ -0,0 +1,8 @@
+drop table order_item;
+drop table size;
+drop table item;
+drop type item_status;
+drop table payment;
+drop table "order";
+drop table address;
+drop table customer;
@@ -48,14 +48,6 @@ create table payment
     unique (order_id)
 );
 
--- базовые модели товаров
--- create table item
--- (
---     id       uuid primary key default gen_random_uuid(),
---     name     text not null,
---     category text
--- );
-
 create type item_status as enum (
     'pending',
     'processing',
@@ -72,12 +64,11 @@ create table item
     price bigint,
     name  text,
     brand text
-
 );
 
 create table size
 (
-    chrt_id   bigint primary key, -- числовой id размера для данного артикула
+    id        bigint primary key, -- chrt_id: числовой id размера для данного артикула
     nm_id     uuid references item (nm_id),
     tech_size text,               -- технический размер ("0", "m", "l")
     sku       text,               -- баркод
@@ -85,12 +76,11 @@ create table size
     name      text                -- название варианта
 );
 
-
 create table order_item
 (
     rid         uuid primary key default gen_random_uuid(),
     order_id    uuid    not null references "order" (id),
-    item_id     uuid    not null references item (nm_id),
+    nm_id       uuid    not null references item (nm_id),
     chrt_id     bigint  not null references size (id),
     price       integer not null,           -- цена за единицу на момент заказа
     sale        integer          default 0, -- скидка % на эту позицию
@@ -100,36 +90,6 @@ create table order_item
     created     timestamp        default now()
 );
 
--- типы характеристик
--- create table attribute
--- (
---     id        uuid primary key default gen_random_uuid(),
---     name      text not null unique,          -- "цвет", "размер", "материал"
---     type      text not null,                 -- "string", "number", "boolean", "datetime"
---     is_common boolean          default false -- общая для всех вариантов?
--- );
-
--- значения характеристик
--- create table attribute_value
--- (
---     id           uuid primary key default gen_random_uuid(),
---     attribute_id uuid not null references attribute (id),
---     value        text not null, -- "красный", "m", "хлопок"
---     unique (attribute_id, value)
--- );
-
--- связь характеристик с базовой моделью (item)
--- create table item_attribute
--- (
---     item_id      uuid not null references item (id),
---     attribute_id uuid not null references attribute_value (id),
---     unique (item_id, attribute_id)
--- );
-
--- связь характеристик с конкретным вариантом (product)
--- create table product_attribute
--- (
---     nm_id        uuid not null references product (nm_id),
---     attribute_id uuid not null references attribute_value (id),
---     unique (nm_id, attribute_id)
--- );
+create index order_created_id_idx on "order" (created desc, id desc);
+create index order_customer_id_idx on "order" (customer_id);
+create index order_item_order_id_idx on order_item (order_id);
@@ -0,0 +1 @@
+drop table order_item_status_history;
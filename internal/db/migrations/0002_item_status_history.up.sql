@@ -0,0 +1,19 @@
+create table order_item_status_history
+(
+    id               bigint generated always as identity primary key,
+    rid              uuid        not null references order_item (rid),
+    order_id         uuid        not null references "order" (id),
+    previous_status  item_status,          -- null для первого статуса позиции
+    status           item_status not null,
+    source_topic     text,                 -- топик и смещение сообщения, которое изменило статус
+    source_partition integer,
+    source_offset    bigint,
+    changed_at       timestamptz not null default now()
+);
+
+create index order_item_status_history_order_id_idx on order_item_status_history (order_id, changed_at);
+
+insert into order_item_status_history (rid, order_id, status, changed_at)
+select rid, order_id, status, created
+from order_item
+where status is not null;
@@ -0,0 +1,8 @@
+create table idempotency_key
+(
+    key          text primary key,
+    request_hash text        not null, -- sha256 тела запроса
+    status_code  integer     not null,
+    response     bytea       not null, -- тело ответа в том виде, в котором оно было отправлено
+    created_at   timestamptz not null default now()
+);
@@ -0,0 +1,2 @@
+alter table order_item
+    drop column version;
@@ -0,0 +1,3 @@
+-- увеличивается при каждой смене статуса, используется для оптимистичной блокировки
+alter table order_item
+    add column version bigint not null default 1;
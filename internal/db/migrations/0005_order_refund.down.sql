@@ -0,0 +1,5 @@
+alter table order_item_status_history
+    drop column reason;
+
+alter table payment
+    drop column refund;
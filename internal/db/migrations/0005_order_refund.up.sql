@@ -0,0 +1,7 @@
+-- сумма, возвращаемая покупателю за отмененные и возвращенные позиции
+alter table payment
+    add column refund bigint not null default 0;
+
+-- причина отмены или возврата позиции
+alter table order_item_status_history
+    add column reason text;
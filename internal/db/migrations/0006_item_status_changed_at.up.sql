@@ -0,0 +1,7 @@
+-- время последней смены статуса позиции; события о статусах, которые старше него, не применяются
+alter table order_item
+    add column status_changed_at timestamptz not null default now();
+
+update order_item oi
+set status_changed_at = coalesce((select max(h.changed_at) from order_item_status_history h where h.rid = oi.rid),
+                                 oi.created, now());
@@ -0,0 +1,15 @@
+create table outbox
+(
+    id              bigint generated always as identity primary key,
+    event_id        uuid        not null unique,
+    event_type      text        not null,              -- OrderCreated, ItemStatusChanged
+    order_id        uuid        not null,              -- ключ сообщения Kafka
+    payload         jsonb       not null,
+    attempts        integer     not null default 0,
+    last_error      text,
+    next_attempt_at timestamptz not null default now(), -- до этого времени событие занято relay или ждет повтора
+    created_at      timestamptz not null default now()
+);
+
+create index outbox_next_attempt_at_idx on outbox (next_attempt_at, id);
+create index outbox_order_id_idx on outbox (order_id, id);
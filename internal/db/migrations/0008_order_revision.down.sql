@@ -0,0 +1,2 @@
+alter table "order"
+    drop column revision;
@@ -0,0 +1,3 @@
+-- номер изменения заказа; передается в уведомлениях order_changed, чтобы реплики отличали устаревшие копии в кеше
+alter table "order"
+    add column revision bigint not null default 0;
@@ -0,0 +1,8 @@
+drop index idempotency_key_created_at_idx;
+
+delete from idempotency_key
+where status_code is null;
+
+alter table idempotency_key
+    alter column status_code set not null,
+    alter column response set not null;
@@ -0,0 +1,7 @@
+-- ключ резервируется до обработки запроса: пока запрос обрабатывается, status_code и response пусты
+alter table idempotency_key
+    alter column status_code drop not null,
+    alter column response drop not null;
+
+-- по нему удаляются ключи старше idempotency.ttl
+create index idempotency_key_created_at_idx on idempotency_key (created_at);
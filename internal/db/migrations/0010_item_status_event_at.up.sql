@@ -0,0 +1,3 @@
+-- время последнего примененного события статуса по часам логистики, пусто до первого события; события старше него не применяются
+alter table order_item
+    add column status_event_at timestamptz;
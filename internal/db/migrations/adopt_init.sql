@@ -0,0 +1,41 @@
+-- Приводит схему, созданную старым db/init.sql, к состоянию после 0001_init.
+-- В старом скрипте order_item ссылалась на несуществующую колонку size.id, поэтому таблица не создавалась;
+-- если ее создали вручную, в ней осталась колонка item_id вместо nm_id.
+do
+$$
+    begin
+        if exists (select 1
+                   from information_schema.columns
+                   where table_schema = current_schema()
+                     and table_name = 'size'
+                     and column_name = 'chrt_id') then
+            alter table size rename column chrt_id to id;
+        end if;
+
+        if exists (select 1
+                   from information_schema.columns
+                   where table_schema = current_schema()
+                     and table_name = 'order_item'
+                     and column_name = 'item_id') then
+            alter table order_item rename column item_id to nm_id;
+        end if;
+    end
+$$;
+
+create table if not exists order_item
+(
+    rid         uuid primary key default gen_random_uuid(),
+    order_id    uuid    not null references "order" (id),
+    nm_id       uuid    not null references item (nm_id),
+    chrt_id     bigint  not null references size (id),
+    price       integer not null,           -- цена за единицу на момент заказа
+    sale        integer          default 0, -- скидка % на эту позицию
+    quantity    integer          default 1, -- количество
+    total_price integer not null,           -- итоговая стоимость позиции
+    status      item_status,
+    created     timestamp        default now()
+);
+
+create index if not exists order_created_id_idx on "order" (created desc, id desc);
+create index if not exists order_customer_id_idx on "order" (customer_id);
+create index if not exists order_item_order_id_idx on order_item (order_id);
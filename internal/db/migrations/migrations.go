@@ -0,0 +1,285 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// adoptInit brings a schema created by the former db/init.sql to the state
+// after 0001_init, which is then recorded instead of being applied.
+//
+//go:embed adopt_init.sql
+var adoptInit string
+
+var ErrSchemaOutdated = errors.New("database schema is outdated, run the migrate command")
+
+// lockID serializes concurrent migrators through a transaction-level
+// advisory lock.
+const lockID = 7_200_310_155
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type Migrator struct {
+	db         DB
+	migrations []Migration
+}
+
+func New(db DB) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, errors.Newf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, errors.Newf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, errors.Newf("migration versions must be sequential from 1, got %d at position %d", m.Version, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Latest returns the version the code expects the schema to be at.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Version returns the currently applied schema version, 0 for an empty database.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := m.db.QueryRow(ctx, `select coalesce(max(version), 0) from schema_version`).Scan(&version)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return version, nil
+}
+
+// Check returns ErrSchemaOutdated unless every known migration is applied.
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version < m.Latest() {
+		return errors.WithDetailf(ErrSchemaOutdated, "schema version %d, expected %d", version, m.Latest())
+	}
+	if version > m.Latest() {
+		return errors.Newf("schema version %d is newer than this build supports (%d)", version, m.Latest())
+	}
+
+	return nil
+}
+
+// Up applies all pending migrations, each in its own transaction. A database
+// without recorded migrations whose tables already exist was created by the
+// former db/init.sql; it is adopted as version 1 rather than initialized.
+func (m *Migrator) Up(ctx context.Context) error {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		applied, err := m.step(ctx, migration.Version-1, func(tx pgx.Tx) error {
+			up := migration.Up
+			if migration.Version == 1 {
+				var legacy bool
+				err := tx.QueryRow(ctx, `select to_regclass('"order"') is not null`).Scan(&legacy)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				if legacy {
+					log.Info().Msg("Adopting schema created by db/init.sql")
+					up = adoptInit
+				}
+			}
+
+			if _, err := tx.Exec(ctx, up); err != nil {
+				return errors.Wrapf(err, "migration %d_%s", migration.Version, migration.Name)
+			}
+
+			_, err := tx.Exec(ctx, `insert into schema_version (version, name) values ($1, $2)`,
+				migration.Version, migration.Name)
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return err
+		}
+		if applied {
+			log.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Applied migration")
+		}
+	}
+
+	return nil
+}
+
+// Down reverts the last steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; i < steps; i++ {
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			return nil
+		}
+		if version > m.Latest() {
+			return errors.Newf("schema version %d is unknown to this build", version)
+		}
+
+		migration := m.migrations[version-1]
+		_, err = m.step(ctx, version, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, migration.Down); err != nil {
+				return errors.Wrapf(err, "migration %d_%s", migration.Version, migration.Name)
+			}
+
+			_, err := tx.Exec(ctx, `delete from schema_version where version = $1`, migration.Version)
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Reverted migration")
+	}
+
+	return nil
+}
+
+// step runs apply in a locked transaction if the schema is still at from,
+// so concurrent migrators do not apply the same migration twice.
+func (m *Migrator) step(ctx context.Context, from int, apply func(tx pgx.Tx) error) (bool, error) {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err = tx.Exec(ctx, `select pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	var version int
+	err = tx.QueryRow(ctx, `select coalesce(max(version), 0) from schema_version`).Scan(&version)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if version != from {
+		return false, nil
+	}
+
+	if err = apply(tx); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	_, err := m.db.Exec(ctx, `
+        create table if not exists schema_version
+        (
+            version    integer primary key,
+            name       text        not null,
+            applied_at timestamptz not null default now()
+        )
+    `)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package migrations_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/db/migrations"
+)
+
+func TestMigrator_Check(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	m, err := migrations.New(pool)
+	require.NoError(t, err)
+	require.Positive(t, m.Latest())
+
+	pool.ExpectExec("create table if not exists schema_version").
+		WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	pool.ExpectQuery("select coalesce").
+		WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(0))
+
+	err = m.Check(context.Background())
+	require.ErrorIs(t, err, migrations.ErrSchemaOutdated)
+
+	pool.ExpectExec("create table if not exists schema_version").
+		WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	pool.ExpectQuery("select coalesce").
+		WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(m.Latest()))
+
+	require.NoError(t, m.Check(context.Background()))
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestMigrator_Up(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	m, err := migrations.New(pool)
+	require.NoError(t, err)
+
+	pool.ExpectExec("create table if not exists schema_version").
+		WillReturnResult(pgxmock.NewResult("CREATE", 0))
+
+	for version := 1; version <= m.Latest(); version++ {
+		pool.ExpectBegin()
+		pool.ExpectExec("pg_advisory_xact_lock").WithArgs(pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("SELECT", 1))
+		pool.ExpectQuery("select coalesce").
+			WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(version - 1))
+		if version == 1 {
+			pool.ExpectQuery("to_regclass").WillReturnRows(pgxmock.NewRows([]string{"legacy"}).AddRow(false))
+		}
+		pool.ExpectExec("create|alter|drop").WillReturnResult(pgxmock.NewResult("CREATE", 0))
+		pool.ExpectExec("insert into schema_version").WithArgs(version, pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		pool.ExpectCommit()
+		pool.ExpectRollback()
+	}
+
+	require.NoError(t, m.Up(context.Background()))
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestMigrator_Up_AdoptsLegacySchema(t *testing.T) {
+	// The existing tables are kept; only the differences from 0001_init are
+	// applied.
+	tests := map[string]string{
+		"size":               `rename column chrt_id to id`,
+		"missing order_item": `create table if not exists order_item\s*\(.*nm_id\s+uuid\s+not null`,
+		"order_item with item_id": `table_name = 'order_item'\s+and column_name = 'item_id'.*` +
+			`alter table order_item rename column item_id to nm_id`,
+	}
+
+	for name, adopt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pool, err := pgxmock.NewPool()
+			require.NoError(t, err)
+			defer pool.Close()
+
+			m, err := migrations.New(pool)
+			require.NoError(t, err)
+
+			pool.ExpectExec("create table if not exists schema_version").
+				WillReturnResult(pgxmock.NewResult("CREATE", 0))
+
+			pool.ExpectBegin()
+			pool.ExpectExec("pg_advisory_xact_lock").WithArgs(pgxmock.AnyArg()).
+				WillReturnResult(pgxmock.NewResult("SELECT", 1))
+			pool.ExpectQuery("select coalesce").WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(0))
+			pool.ExpectQuery("to_regclass").WillReturnRows(pgxmock.NewRows([]string{"legacy"}).AddRow(true))
+			pool.ExpectExec("(?s)" + adopt).WillReturnResult(pgxmock.NewResult("DO", 0))
+			pool.ExpectExec("insert into schema_version").WithArgs(1, "init").
+				WillReturnResult(pgxmock.NewResult("INSERT", 1))
+			pool.ExpectCommit()
+			pool.ExpectRollback()
+
+			for version := 2; version <= m.Latest(); version++ {
+				pool.ExpectBegin()
+				pool.ExpectExec("pg_advisory_xact_lock").WithArgs(pgxmock.AnyArg()).
+					WillReturnResult(pgxmock.NewResult("SELECT", 1))
+				pool.ExpectQuery("select coalesce").
+					WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(version - 1))
+				pool.ExpectExec("create|alter|drop").WillReturnResult(pgxmock.NewResult("CREATE", 0))
+				pool.ExpectExec("insert into schema_version").WithArgs(version, pgxmock.AnyArg()).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				pool.ExpectCommit()
+				pool.ExpectRollback()
+			}
+
+			require.NoError(t, m.Up(context.Background()))
+			require.NoError(t, pool.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMigrator_Up_AlreadyApplied(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	m, err := migrations.New(pool)
+	require.NoError(t, err)
+
+	pool.ExpectExec("create table if not exists schema_version").
+		WillReturnResult(pgxmock.NewResult("CREATE", 0))
+
+	for version := 1; version <= m.Latest(); version++ {
+		pool.ExpectBegin()
+		pool.ExpectExec("pg_advisory_xact_lock").WithArgs(pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("SELECT", 1))
+		pool.ExpectQuery("select coalesce").
+			WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(m.Latest()))
+		pool.ExpectRollback()
+	}
+
+	require.NoError(t, m.Up(context.Background()))
+	require.NoError(t, pool.ExpectationsWereMet())
+}
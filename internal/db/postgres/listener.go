@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// ListenConn is the dedicated connection a Listener waits for notifications
+// on. Close must not return it to a pool, since it stays subscribed.
+type ListenConn interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	WaitForNotification(ctx context.Context) (*pgconn.Notification, error)
+	Close(ctx context.Context) error
+}
+
+// Connector opens a ListenConn.
+type Connector func(ctx context.Context) (ListenConn, error)
+
+// PoolConnector takes listener connections from pool. They are detached
+// from the pool and closed when the listener is done with them.
+func PoolConnector(pool *pgxpool.Pool) Connector {
+	return func(ctx context.Context) (ListenConn, error) {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return conn.Hijack(), nil
+	}
+}
+
+// Listener delivers the payloads of NOTIFY messages on a channel to a
+// handler, reconnecting with backoff when the connection is lost.
+type Listener struct {
+	connect Connector
+	channel string
+	handle  func(payload string)
+	resync  func()
+}
+
+// NewListener creates a listener on channel. resync, if not nil, is called
+// after every reconnect because notifications sent while disconnected are
+// lost.
+func NewListener(connect Connector, channel string, handle func(payload string), resync func()) *Listener {
+	return &Listener{
+		connect: connect,
+		channel: channel,
+		handle:  handle,
+		resync:  resync,
+	}
+}
+
+// Run listens until ctx is cancelled.
+func (l *Listener) Run(ctx context.Context) error {
+	delay := minReconnectDelay
+
+	for reconnect := false; ; reconnect = true {
+		subscribed, err := l.listen(ctx, reconnect)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if subscribed {
+			delay = minReconnectDelay
+		}
+
+		log.Warn().Err(err).Str("channel", l.channel).Dur("delay", delay).
+			Msg("Lost notification connection, reconnecting")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// listen subscribes on a fresh connection and handles notifications until
+// the connection fails. It reports whether the subscription succeeded.
+func (l *Listener) listen(ctx context.Context, reconnect bool) (bool, error) {
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = conn.Close(closeCtx)
+	}()
+
+	_, err = conn.Exec(ctx, "listen "+pgx.Identifier{l.channel}.Sanitize())
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	if reconnect && l.resync != nil {
+		l.resync()
+	}
+
+	log.Info().Str("channel", l.channel).Msg("Listening for notifications")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, errors.WithStack(err)
+		}
+
+		l.handle(notification.Payload)
+	}
+}
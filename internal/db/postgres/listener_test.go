@@ -0,0 +1,109 @@
+package postgres_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/db/postgres"
+)
+
+type fakeConn struct {
+	notifications chan *pgconn.Notification
+	closed        chan struct{}
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		notifications: make(chan *pgconn.Notification),
+		closed:        make(chan struct{}),
+	}
+}
+
+func (c *fakeConn) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	if sql != `listen "order_changed"` {
+		return pgconn.CommandTag{}, errors.Newf("unexpected statement %q", sql)
+	}
+	return pgconn.NewCommandTag("LISTEN"), nil
+}
+
+func (c *fakeConn) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case n, ok := <-c.notifications:
+		if !ok {
+			return nil, errors.New("conn closed")
+		}
+		return n, nil
+	}
+}
+
+func (c *fakeConn) Close(_ context.Context) error {
+	close(c.closed)
+	return nil
+}
+
+func TestListener_Reconnect(t *testing.T) {
+	conns := make(chan *fakeConn, 2)
+	connect := func(ctx context.Context) (postgres.ListenConn, error) {
+		select {
+		case conn := <-conns:
+			return conn, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var mu sync.Mutex
+	var payloads []string
+	received := make(chan struct{}, 10)
+	resynced := make(chan struct{}, 10)
+
+	l := postgres.NewListener(connect, "order_changed",
+		func(payload string) {
+			mu.Lock()
+			payloads = append(payloads, payload)
+			mu.Unlock()
+			received <- struct{}{}
+		},
+		func() { resynced <- struct{}{} },
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.Run(ctx) }()
+
+	first := newFakeConn()
+	conns <- first
+	first.notifications <- &pgconn.Notification{Payload: "a"}
+	<-received
+
+	// Dropping the connection makes the listener reconnect and resync.
+	close(first.notifications)
+	<-first.closed
+
+	second := newFakeConn()
+	conns <- second
+	select {
+	case <-resynced:
+	case <-time.After(time.Second):
+		t.Fatal("listener did not resync after reconnect")
+	}
+
+	second.notifications <- &pgconn.Notification{Payload: "b"}
+	<-received
+
+	cancel()
+	require.NoError(t, <-done)
+	<-second.closed
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"a", "b"}, payloads)
+	require.Empty(t, resynced)
+}
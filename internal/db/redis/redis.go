@@ -1 +1,107 @@
 package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"order_service/internal/metrics"
+	"order_service/internal/model"
+)
+
+// keyPrefix namespaces cached orders; bump the version when the encoding of
+// model.Order changes so that old entries are ignored.
+const keyPrefix = "order_service:order:v2:"
+
+// opTimeout bounds every cache round-trip. A slow Redis must not be slower
+// than going to Postgres.
+const opTimeout = 200 * time.Millisecond
+
+func Client(ctx context.Context, url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	client := redis.NewClient(opts)
+
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		_ = client.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	return client, nil
+}
+
+// Cache stores orders in Redis so that all replicas share them. Failures are
+// logged, counted in metrics.RedisErrors and reported as misses: the cache is
+// never the source of truth, so a Redis outage must not make the service
+// unready.
+type Cache struct {
+	client redis.UniversalClient
+	ttl    time.Duration
+}
+
+func NewCache(client redis.UniversalClient, ttl time.Duration) *Cache {
+	return &Cache{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+func (c *Cache) Set(key string, order model.Order) {
+	data, err := json.Marshal(order)
+	if err != nil {
+		log.Error().Stack().Err(errors.WithStack(err)).Str("key", key).Msg("redis cache: encode order")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+
+	err = c.client.Set(ctx, keyPrefix+key, data, c.ttl).Err()
+	if err != nil {
+		metrics.RedisErrors.WithLabelValues("set").Inc()
+		log.Warn().Err(err).Str("key", key).Msg("redis cache: set")
+	}
+}
+
+// Get returns the order stored under key.
+func (c *Cache) Get(key string) (model.Order, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, keyPrefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			metrics.RedisErrors.WithLabelValues("get").Inc()
+			log.Warn().Err(err).Str("key", key).Msg("redis cache: get")
+		}
+		return model.Order{}, false
+	}
+
+	var order model.Order
+	err = json.Unmarshal(data, &order)
+	if err != nil {
+		log.Error().Stack().Err(errors.WithStack(err)).Str("key", key).Msg("redis cache: decode order")
+		return model.Order{}, false
+	}
+
+	return order, true
+}
+
+// Delete removes the order stored under key.
+func (c *Cache) Delete(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+
+	err := c.client.Del(ctx, keyPrefix+key).Err()
+	if err != nil {
+		metrics.RedisErrors.WithLabelValues("delete").Inc()
+		log.Warn().Err(err).Str("key", key).Msg("redis cache: delete")
+	}
+}
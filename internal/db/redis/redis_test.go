@@ -0,0 +1,153 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/db/redis"
+	"order_service/internal/metrics"
+	"order_service/internal/model"
+)
+
+func TestCache(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	client, err := redis.Client(context.Background(), "redis://"+server.Addr())
+	require.NoError(t, err)
+	defer client.Close()
+
+	c := redis.NewCache(client, time.Minute)
+	order := createTestOrder()
+
+	_, ok := c.Get(order.ID.String())
+	require.False(t, ok)
+
+	c.Set(order.ID.String(), order)
+
+	cached, ok := c.Get(order.ID.String())
+	require.True(t, ok)
+	require.Equal(t, order, cached)
+
+	server.FastForward(2 * time.Minute)
+
+	_, ok = c.Get(order.ID.String())
+	require.False(t, ok)
+}
+
+func TestCache_Delete(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	client, err := redis.Client(context.Background(), "redis://"+server.Addr())
+	require.NoError(t, err)
+	defer client.Close()
+
+	c := redis.NewCache(client, time.Minute)
+	order := createTestOrder()
+
+	c.Set(order.ID.String(), order)
+	c.Delete(order.ID.String())
+
+	_, ok := c.Get(order.ID.String())
+	require.False(t, ok)
+
+	// Deleting a missing order is not an error.
+	c.Delete(order.ID.String())
+}
+
+func TestCache_IgnoresCorruptValues(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	client, err := redis.Client(context.Background(), "redis://"+server.Addr())
+	require.NoError(t, err)
+	defer client.Close()
+
+	c := redis.NewCache(client, time.Minute)
+
+	require.NoError(t, server.Set("order_service:order:v2:broken", "{"))
+	_, ok := c.Get("broken")
+	require.False(t, ok)
+}
+
+func TestCache_Unavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	client, err := redis.Client(context.Background(), "redis://"+server.Addr())
+	require.NoError(t, err)
+	defer client.Close()
+
+	c := redis.NewCache(client, time.Minute)
+	server.Close()
+
+	order := createTestOrder()
+	c.Set(order.ID.String(), order)
+
+	failures := testutil.ToFloat64(metrics.RedisErrors.WithLabelValues("get"))
+
+	_, ok := c.Get(order.ID.String())
+	require.False(t, ok)
+	require.Equal(t, failures+1, testutil.ToFloat64(metrics.RedisErrors.WithLabelValues("get")))
+}
+
+func createTestOrder() model.Order {
+	orderID := uuid.New()
+	customerID := uuid.New()
+
+	return model.Order{
+		ID:              orderID,
+		TrackNumber:     "WBILMTESTTRACK",
+		Entry:           "WBIL",
+		Locale:          "en",
+		DeliveryService: "meest",
+		SmID:            99,
+		Created:         time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+		Customer: model.Customer{
+			ID:    customerID,
+			Name:  "Test Testov",
+			Email: "test@example.com",
+			Phone: "+79990000000",
+		},
+		Address: model.Address{
+			ID:         uuid.New(),
+			CustomerID: customerID,
+			Zip:        "2639809",
+			City:       "Kiryat Mozkin",
+			Address:    "Ploshad Mira 15",
+			Region:     "Kraiot",
+		},
+		Payment: model.Payment{
+			ID:            uuid.New(),
+			OrderID:       orderID,
+			TransactionID: uuid.New(),
+			Currency:      "USD",
+			Provider:      "wbpay",
+			Amount:        1817,
+			Timestamp:     1637907727,
+			Bank:          "alpha",
+			DeliveryCost:  1500,
+			GoodsTotal:    317,
+		},
+		Items: []model.OrderItem{
+			{
+				ID:      uuid.New(),
+				OrderID: orderID,
+				Item: model.Item{
+					ID:    uuid.New(),
+					Name:  "Mascaras",
+					Brand: "Vivienne Sabo",
+				},
+				ChrtID:     9934930,
+				Price:      453,
+				Sale:       30,
+				Quantity:   1,
+				TotalPrice: 317,
+				Status:     model.Pending,
+				Size:       "0",
+			},
+		},
+	}
+}
@@ -0,0 +1,73 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Component is a long-running part of the application. Run blocks until the
+// component finishes or ctx is cancelled. Stop, if set, is called once ctx is
+// cancelled and must make Run return.
+type Component struct {
+	Name string
+	Run  func(ctx context.Context) error
+	Stop func(ctx context.Context) error
+}
+
+// Run starts all components and waits for them to finish. When ctx is
+// cancelled or any component fails, the remaining components are stopped,
+// each Stop being given at most timeout to drain.
+func Run(ctx context.Context, timeout time.Duration, components ...Component) error {
+	g, gCtx := errgroup.WithContext(ctx)
+	gCtx, cancel := context.WithCancel(gCtx)
+	defer cancel()
+
+	var running sync.WaitGroup
+	for _, c := range components {
+		running.Add(1)
+		g.Go(func() error {
+			defer running.Done()
+
+			log.Info().Str("component", c.Name).Msg("Starting component...")
+
+			err := c.Run(gCtx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return errors.Wrapf(err, "%s", c.Name)
+			}
+
+			log.Info().Str("component", c.Name).Msg("Component finished")
+			return nil
+		})
+
+		if c.Stop == nil {
+			continue
+		}
+
+		g.Go(func() error {
+			<-gCtx.Done()
+
+			stopCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			log.Info().Str("component", c.Name).Msg("Stopping component...")
+			if err := c.Stop(stopCtx); err != nil {
+				return errors.Wrapf(err, "stop %s", c.Name)
+			}
+
+			return nil
+		})
+	}
+
+	// Release the Stop goroutines once every component has returned on its own.
+	go func() {
+		running.Wait()
+		cancel()
+	}()
+
+	return g.Wait()
+}
@@ -0,0 +1,91 @@
+package lifecycle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/lifecycle"
+)
+
+func blockingComponent(name string, stopped *bool) lifecycle.Component {
+	done := make(chan struct{})
+	return lifecycle.Component{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			<-done
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			*stopped = true
+			close(done)
+			return nil
+		},
+	}
+}
+
+func TestRun_StopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var stopped bool
+	finished := false
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	err := lifecycle.Run(ctx, time.Second,
+		blockingComponent("server", &stopped),
+		lifecycle.Component{
+			Name: "warm-up",
+			Run: func(ctx context.Context) error {
+				finished = true
+				return nil
+			},
+		},
+	)
+
+	require.NoError(t, err)
+	require.True(t, stopped)
+	require.True(t, finished)
+}
+
+func TestRun_FailureStopsOthers(t *testing.T) {
+	testErr := errors.New("broker unavailable")
+
+	var stopped bool
+	err := lifecycle.Run(context.Background(), time.Second,
+		blockingComponent("server", &stopped),
+		lifecycle.Component{
+			Name: "processor",
+			Run: func(ctx context.Context) error {
+				return testErr
+			},
+		},
+	)
+
+	require.ErrorIs(t, err, testErr)
+	require.True(t, stopped)
+}
+
+func TestRun_AllComponentsFinished(t *testing.T) {
+	var stopped bool
+	err := lifecycle.Run(context.Background(), time.Second,
+		lifecycle.Component{
+			Name: "warm-up",
+			Run: func(ctx context.Context) error {
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				stopped = true
+				return nil
+			},
+		},
+	)
+
+	require.NoError(t, err)
+	require.True(t, stopped)
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "order_service"
+
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "messages_consumed_total",
+		Help:      "Kafka messages received by the consumer.",
+	}, []string{"topic", "partition"})
+
+	MessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "messages_failed_total",
+		Help:      "Kafka messages that could not be processed, by error class.",
+	}, []string{"topic", "partition", "class"})
+
+	MessagesRetried = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "messages_retried_total",
+		Help:      "Processing retries of Kafka messages after transient failures.",
+	}, []string{"topic", "partition"})
+
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "consumer_lag",
+		Help:      "Messages between the last consumed offset and the partition high water mark.",
+	}, []string{"topic", "partition"})
+
+	CacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "hits_total",
+		Help:      "Cache lookups that found a live entry.",
+	}, []string{"cache"})
+
+	CacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "misses_total",
+		Help:      "Cache lookups that found no live entry.",
+	}, []string{"cache"})
+
+	CacheNegativeHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "negative_hits_total",
+		Help:      "Lookups answered from the cache of unknown order IDs.",
+	})
+
+	CacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "Entries removed to make room for new ones.",
+	}, []string{"cache"})
+
+	CacheExpirations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "expirations_total",
+		Help:      "Entries removed because their TTL elapsed.",
+	}, []string{"cache"})
+
+	RedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "errors_total",
+		Help:      "Failed Redis cache operations, served from Postgres instead.",
+	}, []string{"operation"})
+
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "repository",
+		Name:      "query_duration_seconds",
+		Help:      "Repository method latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "status"})
+
+	TransitionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "order",
+		Name:      "item_transitions_rejected_total",
+		Help:      "Item status updates rejected by the status state machine.",
+	}, []string{"from", "to"})
+
+	StatusEventsStale = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "order",
+		Name:      "item_status_events_stale_total",
+		Help:      "Item status events skipped as older than the item's last status change.",
+	})
+
+	OutboxEventsPublished = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "outbox",
+		Name:      "events_published_total",
+		Help:      "Outbox events acknowledged by Kafka.",
+	})
+
+	OutboxPublishFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "outbox",
+		Name:      "publish_failures_total",
+		Help:      "Outbox batches cut short by an event Kafka did not accept.",
+	})
+)
+
+// RegisterPool exposes pgxpool statistics on the default registry.
+func RegisterPool(pool *pgxpool.Pool) error {
+	return prometheus.Register(&poolCollector{pool: pool})
+}
+
+var (
+	poolAcquiredConns = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "acquired_conns"),
+		"Connections currently in use.", nil, nil)
+	poolIdleConns = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "idle_conns"),
+		"Idle connections in the pool.", nil, nil)
+	poolTotalConns = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "total_conns"),
+		"Total connections in the pool.", nil, nil)
+	poolMaxConns = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "max_conns"),
+		"Maximum size of the pool.", nil, nil)
+	poolAcquireCount = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "acquire_total"),
+		"Successful connection acquisitions.", nil, nil)
+	poolEmptyAcquireCount = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "empty_acquire_total"),
+		"Acquisitions that had to wait for a connection.", nil, nil)
+	poolAcquireDuration = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "acquire_duration_seconds_total"),
+		"Total time spent acquiring connections.", nil, nil)
+)
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquireCount
+	ch <- poolEmptyAcquireCount
+	ch <- poolAcquireDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -24,6 +24,123 @@ func (_m *Service) EXPECT() *Service_Expecter {
 	return &Service_Expecter{mock: &_m.Mock}
 }
 
+// CancelOrder provides a mock function with given fields: ctx, orderID, reason
+func (_m *Service) CancelOrder(ctx context.Context, orderID uuid.UUID, reason string) (model.Order, error) {
+	ret := _m.Called(ctx, orderID, reason)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CancelOrder")
+	}
+
+	var r0 model.Order
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) (model.Order, error)); ok {
+		return rf(ctx, orderID, reason)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) model.Order); ok {
+		r0 = rf(ctx, orderID, reason)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, string) error); ok {
+		r1 = rf(ctx, orderID, reason)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_CancelOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CancelOrder'
+type Service_CancelOrder_Call struct {
+	*mock.Call
+}
+
+// CancelOrder is a helper method to define mock.On call
+//   - ctx context.Context
+//   - orderID uuid.UUID
+//   - reason string
+func (_e *Service_Expecter) CancelOrder(ctx interface{}, orderID interface{}, reason interface{}) *Service_CancelOrder_Call {
+	return &Service_CancelOrder_Call{Call: _e.mock.On("CancelOrder", ctx, orderID, reason)}
+}
+
+func (_c *Service_CancelOrder_Call) Run(run func(ctx context.Context, orderID uuid.UUID, reason string)) *Service_CancelOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uuid.UUID), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *Service_CancelOrder_Call) Return(_a0 model.Order, _a1 error) *Service_CancelOrder_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_CancelOrder_Call) RunAndReturn(run func(context.Context, uuid.UUID, string) (model.Order, error)) *Service_CancelOrder_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// History provides a mock function with given fields: ctx, orderID
+func (_m *Service) History(ctx context.Context, orderID uuid.UUID) ([]model.StatusChange, error) {
+	ret := _m.Called(ctx, orderID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for History")
+	}
+
+	var r0 []model.StatusChange
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) ([]model.StatusChange, error)); ok {
+		return rf(ctx, orderID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) []model.StatusChange); ok {
+		r0 = rf(ctx, orderID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.StatusChange)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, orderID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_History_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'History'
+type Service_History_Call struct {
+	*mock.Call
+}
+
+// History is a helper method to define mock.On call
+//   - ctx context.Context
+//   - orderID uuid.UUID
+func (_e *Service_Expecter) History(ctx interface{}, orderID interface{}) *Service_History_Call {
+	return &Service_History_Call{Call: _e.mock.On("History", ctx, orderID)}
+}
+
+func (_c *Service_History_Call) Run(run func(ctx context.Context, orderID uuid.UUID)) *Service_History_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uuid.UUID))
+	})
+	return _c
+}
+
+func (_c *Service_History_Call) Return(_a0 []model.StatusChange, _a1 error) *Service_History_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_History_Call) RunAndReturn(run func(context.Context, uuid.UUID) ([]model.StatusChange, error)) *Service_History_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Order provides a mock function with given fields: ctx, orderID
 func (_m *Service) Order(ctx context.Context, orderID uuid.UUID) (model.Order, error) {
 	ret := _m.Called(ctx, orderID)
@@ -81,6 +198,390 @@ func (_c *Service_Order_Call) RunAndReturn(run func(context.Context, uuid.UUID)
 	return _c
 }
 
+// Orders provides a mock function with given fields: ctx, filter
+func (_m *Service) Orders(ctx context.Context, filter model.OrderFilter) (model.OrderPage, error) {
+	ret := _m.Called(ctx, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Orders")
+	}
+
+	var r0 model.OrderPage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderFilter) (model.OrderPage, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderFilter) model.OrderPage); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		r0 = ret.Get(0).(model.OrderPage)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.OrderFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_Orders_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Orders'
+type Service_Orders_Call struct {
+	*mock.Call
+}
+
+// Orders is a helper method to define mock.On call
+//   - ctx context.Context
+//   - filter model.OrderFilter
+func (_e *Service_Expecter) Orders(ctx interface{}, filter interface{}) *Service_Orders_Call {
+	return &Service_Orders_Call{Call: _e.mock.On("Orders", ctx, filter)}
+}
+
+func (_c *Service_Orders_Call) Run(run func(ctx context.Context, filter model.OrderFilter)) *Service_Orders_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(model.OrderFilter))
+	})
+	return _c
+}
+
+func (_c *Service_Orders_Call) Return(_a0 model.OrderPage, _a1 error) *Service_Orders_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_Orders_Call) RunAndReturn(run func(context.Context, model.OrderFilter) (model.OrderPage, error)) *Service_Orders_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ProcessOrder provides a mock function with given fields: ctx, order
+func (_m *Service) ProcessOrder(ctx context.Context, order model.Order) error {
+	ret := _m.Called(ctx, order)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ProcessOrder")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.Order) error); ok {
+		r0 = rf(ctx, order)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Service_ProcessOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ProcessOrder'
+type Service_ProcessOrder_Call struct {
+	*mock.Call
+}
+
+// ProcessOrder is a helper method to define mock.On call
+//   - ctx context.Context
+//   - order model.Order
+func (_e *Service_Expecter) ProcessOrder(ctx interface{}, order interface{}) *Service_ProcessOrder_Call {
+	return &Service_ProcessOrder_Call{Call: _e.mock.On("ProcessOrder", ctx, order)}
+}
+
+func (_c *Service_ProcessOrder_Call) Run(run func(ctx context.Context, order model.Order)) *Service_ProcessOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(model.Order))
+	})
+	return _c
+}
+
+func (_c *Service_ProcessOrder_Call) Return(_a0 error) *Service_ProcessOrder_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Service_ProcessOrder_Call) RunAndReturn(run func(context.Context, model.Order) error) *Service_ProcessOrder_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ReleaseIdempotencyKey provides a mock function with given fields: ctx, key, requestHash
+func (_m *Service) ReleaseIdempotencyKey(ctx context.Context, key string, requestHash string) error {
+	ret := _m.Called(ctx, key, requestHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReleaseIdempotencyKey")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, key, requestHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Service_ReleaseIdempotencyKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReleaseIdempotencyKey'
+type Service_ReleaseIdempotencyKey_Call struct {
+	*mock.Call
+}
+
+// ReleaseIdempotencyKey is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+//   - requestHash string
+func (_e *Service_Expecter) ReleaseIdempotencyKey(ctx interface{}, key interface{}, requestHash interface{}) *Service_ReleaseIdempotencyKey_Call {
+	return &Service_ReleaseIdempotencyKey_Call{Call: _e.mock.On("ReleaseIdempotencyKey", ctx, key, requestHash)}
+}
+
+func (_c *Service_ReleaseIdempotencyKey_Call) Run(run func(ctx context.Context, key string, requestHash string)) *Service_ReleaseIdempotencyKey_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *Service_ReleaseIdempotencyKey_Call) Return(_a0 error) *Service_ReleaseIdempotencyKey_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Service_ReleaseIdempotencyKey_Call) RunAndReturn(run func(context.Context, string, string) error) *Service_ReleaseIdempotencyKey_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ReserveIdempotencyKey provides a mock function with given fields: ctx, key, requestHash
+func (_m *Service) ReserveIdempotencyKey(ctx context.Context, key string, requestHash string) (model.IdempotentResponse, bool, error) {
+	ret := _m.Called(ctx, key, requestHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReserveIdempotencyKey")
+	}
+
+	var r0 model.IdempotentResponse
+	var r1 bool
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (model.IdempotentResponse, bool, error)); ok {
+		return rf(ctx, key, requestHash)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) model.IdempotentResponse); ok {
+		r0 = rf(ctx, key, requestHash)
+	} else {
+		r0 = ret.Get(0).(model.IdempotentResponse)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) bool); ok {
+		r1 = rf(ctx, key, requestHash)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, string, string) error); ok {
+		r2 = rf(ctx, key, requestHash)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// Service_ReserveIdempotencyKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReserveIdempotencyKey'
+type Service_ReserveIdempotencyKey_Call struct {
+	*mock.Call
+}
+
+// ReserveIdempotencyKey is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+//   - requestHash string
+func (_e *Service_Expecter) ReserveIdempotencyKey(ctx interface{}, key interface{}, requestHash interface{}) *Service_ReserveIdempotencyKey_Call {
+	return &Service_ReserveIdempotencyKey_Call{Call: _e.mock.On("ReserveIdempotencyKey", ctx, key, requestHash)}
+}
+
+func (_c *Service_ReserveIdempotencyKey_Call) Run(run func(ctx context.Context, key string, requestHash string)) *Service_ReserveIdempotencyKey_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *Service_ReserveIdempotencyKey_Call) Return(_a0 model.IdempotentResponse, _a1 bool, _a2 error) *Service_ReserveIdempotencyKey_Call {
+	_c.Call.Return(_a0, _a1, _a2)
+	return _c
+}
+
+func (_c *Service_ReserveIdempotencyKey_Call) RunAndReturn(run func(context.Context, string, string) (model.IdempotentResponse, bool, error)) *Service_ReserveIdempotencyKey_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ReturnItems provides a mock function with given fields: ctx, orderID, rids, reason
+func (_m *Service) ReturnItems(ctx context.Context, orderID uuid.UUID, rids []uuid.UUID, reason string) (model.Order, error) {
+	ret := _m.Called(ctx, orderID, rids, reason)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReturnItems")
+	}
+
+	var r0 model.Order
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, []uuid.UUID, string) (model.Order, error)); ok {
+		return rf(ctx, orderID, rids, reason)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, []uuid.UUID, string) model.Order); ok {
+		r0 = rf(ctx, orderID, rids, reason)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, []uuid.UUID, string) error); ok {
+		r1 = rf(ctx, orderID, rids, reason)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_ReturnItems_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReturnItems'
+type Service_ReturnItems_Call struct {
+	*mock.Call
+}
+
+// ReturnItems is a helper method to define mock.On call
+//   - ctx context.Context
+//   - orderID uuid.UUID
+//   - rids []uuid.UUID
+//   - reason string
+func (_e *Service_Expecter) ReturnItems(ctx interface{}, orderID interface{}, rids interface{}, reason interface{}) *Service_ReturnItems_Call {
+	return &Service_ReturnItems_Call{Call: _e.mock.On("ReturnItems", ctx, orderID, rids, reason)}
+}
+
+func (_c *Service_ReturnItems_Call) Run(run func(ctx context.Context, orderID uuid.UUID, rids []uuid.UUID, reason string)) *Service_ReturnItems_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uuid.UUID), args[2].([]uuid.UUID), args[3].(string))
+	})
+	return _c
+}
+
+func (_c *Service_ReturnItems_Call) Return(_a0 model.Order, _a1 error) *Service_ReturnItems_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_ReturnItems_Call) RunAndReturn(run func(context.Context, uuid.UUID, []uuid.UUID, string) (model.Order, error)) *Service_ReturnItems_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SaveIdempotentResponse provides a mock function with given fields: ctx, resp
+func (_m *Service) SaveIdempotentResponse(ctx context.Context, resp model.IdempotentResponse) error {
+	ret := _m.Called(ctx, resp)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveIdempotentResponse")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdempotentResponse) error); ok {
+		r0 = rf(ctx, resp)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Service_SaveIdempotentResponse_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SaveIdempotentResponse'
+type Service_SaveIdempotentResponse_Call struct {
+	*mock.Call
+}
+
+// SaveIdempotentResponse is a helper method to define mock.On call
+//   - ctx context.Context
+//   - resp model.IdempotentResponse
+func (_e *Service_Expecter) SaveIdempotentResponse(ctx interface{}, resp interface{}) *Service_SaveIdempotentResponse_Call {
+	return &Service_SaveIdempotentResponse_Call{Call: _e.mock.On("SaveIdempotentResponse", ctx, resp)}
+}
+
+func (_c *Service_SaveIdempotentResponse_Call) Run(run func(ctx context.Context, resp model.IdempotentResponse)) *Service_SaveIdempotentResponse_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(model.IdempotentResponse))
+	})
+	return _c
+}
+
+func (_c *Service_SaveIdempotentResponse_Call) Return(_a0 error) *Service_SaveIdempotentResponse_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Service_SaveIdempotentResponse_Call) RunAndReturn(run func(context.Context, model.IdempotentResponse) error) *Service_SaveIdempotentResponse_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateItemStatus provides a mock function with given fields: ctx, orderID, rid, status, reason, version
+func (_m *Service) UpdateItemStatus(ctx context.Context, orderID uuid.UUID, rid uuid.UUID, status model.ItemStatus, reason string, version int64) (model.Order, error) {
+	ret := _m.Called(ctx, orderID, rid, status, reason, version)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateItemStatus")
+	}
+
+	var r0 model.Order
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, uuid.UUID, model.ItemStatus, string, int64) (model.Order, error)); ok {
+		return rf(ctx, orderID, rid, status, reason, version)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, uuid.UUID, model.ItemStatus, string, int64) model.Order); ok {
+		r0 = rf(ctx, orderID, rid, status, reason, version)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, uuid.UUID, model.ItemStatus, string, int64) error); ok {
+		r1 = rf(ctx, orderID, rid, status, reason, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_UpdateItemStatus_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateItemStatus'
+type Service_UpdateItemStatus_Call struct {
+	*mock.Call
+}
+
+// UpdateItemStatus is a helper method to define mock.On call
+//   - ctx context.Context
+//   - orderID uuid.UUID
+//   - rid uuid.UUID
+//   - status model.ItemStatus
+//   - reason string
+//   - version int64
+func (_e *Service_Expecter) UpdateItemStatus(ctx interface{}, orderID interface{}, rid interface{}, status interface{}, reason interface{}, version interface{}) *Service_UpdateItemStatus_Call {
+	return &Service_UpdateItemStatus_Call{Call: _e.mock.On("UpdateItemStatus", ctx, orderID, rid, status, reason, version)}
+}
+
+func (_c *Service_UpdateItemStatus_Call) Run(run func(ctx context.Context, orderID uuid.UUID, rid uuid.UUID, status model.ItemStatus, reason string, version int64)) *Service_UpdateItemStatus_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uuid.UUID), args[2].(uuid.UUID), args[3].(model.ItemStatus), args[4].(string), args[5].(int64))
+	})
+	return _c
+}
+
+func (_c *Service_UpdateItemStatus_Call) Return(_a0 model.Order, _a1 error) *Service_UpdateItemStatus_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_UpdateItemStatus_Call) RunAndReturn(run func(context.Context, uuid.UUID, uuid.UUID, model.ItemStatus, string, int64) (model.Order, error)) *Service_UpdateItemStatus_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewService creates a new instance of Service. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewService(t interface {
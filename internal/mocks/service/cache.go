@@ -2,7 +2,11 @@
 
 package mockservice
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	model "order_service/internal/model"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // Cache is an autogenerated mock type for the Cache type
 type Cache struct {
@@ -18,23 +22,31 @@ func (_m *Cache) EXPECT() *Cache_Expecter {
 }
 
 // Get provides a mock function with given fields: key
-func (_m *Cache) Get(key string) interface{} {
+func (_m *Cache) Get(key string) (model.Order, bool) {
 	ret := _m.Called(key)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Get")
 	}
 
-	var r0 interface{}
-	if rf, ok := ret.Get(0).(func(string) interface{}); ok {
+	var r0 model.Order
+	var r1 bool
+	if rf, ok := ret.Get(0).(func(string) (model.Order, bool)); ok {
+		return rf(key)
+	}
+	if rf, ok := ret.Get(0).(func(string) model.Order); ok {
 		r0 = rf(key)
 	} else {
-		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(interface{})
-		}
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) bool); ok {
+		r1 = rf(key)
+	} else {
+		r1 = ret.Get(1).(bool)
 	}
 
-	return r0
+	return r0, r1
 }
 
 // Cache_Get_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Get'
@@ -55,19 +67,19 @@ func (_c *Cache_Get_Call) Run(run func(key string)) *Cache_Get_Call {
 	return _c
 }
 
-func (_c *Cache_Get_Call) Return(_a0 interface{}) *Cache_Get_Call {
-	_c.Call.Return(_a0)
+func (_c *Cache_Get_Call) Return(_a0 model.Order, _a1 bool) *Cache_Get_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *Cache_Get_Call) RunAndReturn(run func(string) interface{}) *Cache_Get_Call {
+func (_c *Cache_Get_Call) RunAndReturn(run func(string) (model.Order, bool)) *Cache_Get_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Set provides a mock function with given fields: key, value
-func (_m *Cache) Set(key string, value interface{}) {
-	_m.Called(key, value)
+// Set provides a mock function with given fields: key, order
+func (_m *Cache) Set(key string, order model.Order) {
+	_m.Called(key, order)
 }
 
 // Cache_Set_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Set'
@@ -77,14 +89,14 @@ type Cache_Set_Call struct {
 
 // Set is a helper method to define mock.On call
 //   - key string
-//   - value interface{}
-func (_e *Cache_Expecter) Set(key interface{}, value interface{}) *Cache_Set_Call {
-	return &Cache_Set_Call{Call: _e.mock.On("Set", key, value)}
+//   - order model.Order
+func (_e *Cache_Expecter) Set(key interface{}, order interface{}) *Cache_Set_Call {
+	return &Cache_Set_Call{Call: _e.mock.On("Set", key, order)}
 }
 
-func (_c *Cache_Set_Call) Run(run func(key string, value interface{})) *Cache_Set_Call {
+func (_c *Cache_Set_Call) Run(run func(key string, order model.Order)) *Cache_Set_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(interface{}))
+		run(args[0].(string), args[1].(model.Order))
 	})
 	return _c
 }
@@ -94,7 +106,7 @@ func (_c *Cache_Set_Call) Return() *Cache_Set_Call {
 	return _c
 }
 
-func (_c *Cache_Set_Call) RunAndReturn(run func(string, interface{})) *Cache_Set_Call {
+func (_c *Cache_Set_Call) RunAndReturn(run func(string, model.Order)) *Cache_Set_Call {
 	_c.Run(run)
 	return _c
 }
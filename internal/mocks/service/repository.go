@@ -7,6 +7,10 @@ import (
 	model "order_service/internal/model"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+
+	uuid "github.com/google/uuid"
 )
 
 // Repository is an autogenerated mock type for the Repository type
@@ -22,6 +26,53 @@ func (_m *Repository) EXPECT() *Repository_Expecter {
 	return &Repository_Expecter{mock: &_m.Mock}
 }
 
+// ChangeItems provides a mock function with given fields: ctx, change
+func (_m *Repository) ChangeItems(ctx context.Context, change model.ItemsChange) error {
+	ret := _m.Called(ctx, change)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ChangeItems")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.ItemsChange) error); ok {
+		r0 = rf(ctx, change)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Repository_ChangeItems_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ChangeItems'
+type Repository_ChangeItems_Call struct {
+	*mock.Call
+}
+
+// ChangeItems is a helper method to define mock.On call
+//   - ctx context.Context
+//   - change model.ItemsChange
+func (_e *Repository_Expecter) ChangeItems(ctx interface{}, change interface{}) *Repository_ChangeItems_Call {
+	return &Repository_ChangeItems_Call{Call: _e.mock.On("ChangeItems", ctx, change)}
+}
+
+func (_c *Repository_ChangeItems_Call) Run(run func(ctx context.Context, change model.ItemsChange)) *Repository_ChangeItems_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(model.ItemsChange))
+	})
+	return _c
+}
+
+func (_c *Repository_ChangeItems_Call) Return(_a0 error) *Repository_ChangeItems_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Repository_ChangeItems_Call) RunAndReturn(run func(context.Context, model.ItemsChange) error) *Repository_ChangeItems_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // CreateOrder provides a mock function with given fields: ctx, order
 func (_m *Repository) CreateOrder(ctx context.Context, order model.Order) (model.Order, error) {
 	ret := _m.Called(ctx, order)
@@ -79,6 +130,63 @@ func (_c *Repository_CreateOrder_Call) RunAndReturn(run func(context.Context, mo
 	return _c
 }
 
+// DeleteIdempotencyKeys provides a mock function with given fields: ctx, ttl
+func (_m *Repository) DeleteIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error) {
+	ret := _m.Called(ctx, ttl)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteIdempotencyKeys")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) (int64, error)); ok {
+		return rf(ctx, ttl)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) int64); ok {
+		r0 = rf(ctx, ttl)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, ttl)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Repository_DeleteIdempotencyKeys_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeleteIdempotencyKeys'
+type Repository_DeleteIdempotencyKeys_Call struct {
+	*mock.Call
+}
+
+// DeleteIdempotencyKeys is a helper method to define mock.On call
+//   - ctx context.Context
+//   - ttl time.Duration
+func (_e *Repository_Expecter) DeleteIdempotencyKeys(ctx interface{}, ttl interface{}) *Repository_DeleteIdempotencyKeys_Call {
+	return &Repository_DeleteIdempotencyKeys_Call{Call: _e.mock.On("DeleteIdempotencyKeys", ctx, ttl)}
+}
+
+func (_c *Repository_DeleteIdempotencyKeys_Call) Run(run func(ctx context.Context, ttl time.Duration)) *Repository_DeleteIdempotencyKeys_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(time.Duration))
+	})
+	return _c
+}
+
+func (_c *Repository_DeleteIdempotencyKeys_Call) Return(_a0 int64, _a1 error) *Repository_DeleteIdempotencyKeys_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Repository_DeleteIdempotencyKeys_Call) RunAndReturn(run func(context.Context, time.Duration) (int64, error)) *Repository_DeleteIdempotencyKeys_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Orders provides a mock function with given fields: ctx, opts
 func (_m *Repository) Orders(ctx context.Context, opts model.OrderFilter) ([]model.Order, error) {
 	ret := _m.Called(ctx, opts)
@@ -138,6 +246,277 @@ func (_c *Repository_Orders_Call) RunAndReturn(run func(context.Context, model.O
 	return _c
 }
 
+// ReleaseIdempotencyKey provides a mock function with given fields: ctx, key, requestHash
+func (_m *Repository) ReleaseIdempotencyKey(ctx context.Context, key string, requestHash string) error {
+	ret := _m.Called(ctx, key, requestHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReleaseIdempotencyKey")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, key, requestHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Repository_ReleaseIdempotencyKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReleaseIdempotencyKey'
+type Repository_ReleaseIdempotencyKey_Call struct {
+	*mock.Call
+}
+
+// ReleaseIdempotencyKey is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+//   - requestHash string
+func (_e *Repository_Expecter) ReleaseIdempotencyKey(ctx interface{}, key interface{}, requestHash interface{}) *Repository_ReleaseIdempotencyKey_Call {
+	return &Repository_ReleaseIdempotencyKey_Call{Call: _e.mock.On("ReleaseIdempotencyKey", ctx, key, requestHash)}
+}
+
+func (_c *Repository_ReleaseIdempotencyKey_Call) Run(run func(ctx context.Context, key string, requestHash string)) *Repository_ReleaseIdempotencyKey_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *Repository_ReleaseIdempotencyKey_Call) Return(_a0 error) *Repository_ReleaseIdempotencyKey_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Repository_ReleaseIdempotencyKey_Call) RunAndReturn(run func(context.Context, string, string) error) *Repository_ReleaseIdempotencyKey_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ReserveIdempotencyKey provides a mock function with given fields: ctx, key, requestHash, lease, ttl
+func (_m *Repository) ReserveIdempotencyKey(ctx context.Context, key string, requestHash string, lease time.Duration, ttl time.Duration) (model.IdempotentResponse, bool, error) {
+	ret := _m.Called(ctx, key, requestHash, lease, ttl)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReserveIdempotencyKey")
+	}
+
+	var r0 model.IdempotentResponse
+	var r1 bool
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration, time.Duration) (model.IdempotentResponse, bool, error)); ok {
+		return rf(ctx, key, requestHash, lease, ttl)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration, time.Duration) model.IdempotentResponse); ok {
+		r0 = rf(ctx, key, requestHash, lease, ttl)
+	} else {
+		r0 = ret.Get(0).(model.IdempotentResponse)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Duration, time.Duration) bool); ok {
+		r1 = rf(ctx, key, requestHash, lease, ttl)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, string, string, time.Duration, time.Duration) error); ok {
+		r2 = rf(ctx, key, requestHash, lease, ttl)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// Repository_ReserveIdempotencyKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReserveIdempotencyKey'
+type Repository_ReserveIdempotencyKey_Call struct {
+	*mock.Call
+}
+
+// ReserveIdempotencyKey is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+//   - requestHash string
+//   - lease time.Duration
+//   - ttl time.Duration
+func (_e *Repository_Expecter) ReserveIdempotencyKey(ctx interface{}, key interface{}, requestHash interface{}, lease interface{}, ttl interface{}) *Repository_ReserveIdempotencyKey_Call {
+	return &Repository_ReserveIdempotencyKey_Call{Call: _e.mock.On("ReserveIdempotencyKey", ctx, key, requestHash, lease, ttl)}
+}
+
+func (_c *Repository_ReserveIdempotencyKey_Call) Run(run func(ctx context.Context, key string, requestHash string, lease time.Duration, ttl time.Duration)) *Repository_ReserveIdempotencyKey_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string), args[3].(time.Duration), args[4].(time.Duration))
+	})
+	return _c
+}
+
+func (_c *Repository_ReserveIdempotencyKey_Call) Return(_a0 model.IdempotentResponse, _a1 bool, _a2 error) *Repository_ReserveIdempotencyKey_Call {
+	_c.Call.Return(_a0, _a1, _a2)
+	return _c
+}
+
+func (_c *Repository_ReserveIdempotencyKey_Call) RunAndReturn(run func(context.Context, string, string, time.Duration, time.Duration) (model.IdempotentResponse, bool, error)) *Repository_ReserveIdempotencyKey_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SaveIdempotentResponse provides a mock function with given fields: ctx, resp
+func (_m *Repository) SaveIdempotentResponse(ctx context.Context, resp model.IdempotentResponse) error {
+	ret := _m.Called(ctx, resp)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveIdempotentResponse")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdempotentResponse) error); ok {
+		r0 = rf(ctx, resp)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Repository_SaveIdempotentResponse_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SaveIdempotentResponse'
+type Repository_SaveIdempotentResponse_Call struct {
+	*mock.Call
+}
+
+// SaveIdempotentResponse is a helper method to define mock.On call
+//   - ctx context.Context
+//   - resp model.IdempotentResponse
+func (_e *Repository_Expecter) SaveIdempotentResponse(ctx interface{}, resp interface{}) *Repository_SaveIdempotentResponse_Call {
+	return &Repository_SaveIdempotentResponse_Call{Call: _e.mock.On("SaveIdempotentResponse", ctx, resp)}
+}
+
+func (_c *Repository_SaveIdempotentResponse_Call) Run(run func(ctx context.Context, resp model.IdempotentResponse)) *Repository_SaveIdempotentResponse_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(model.IdempotentResponse))
+	})
+	return _c
+}
+
+func (_c *Repository_SaveIdempotentResponse_Call) Return(_a0 error) *Repository_SaveIdempotentResponse_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Repository_SaveIdempotentResponse_Call) RunAndReturn(run func(context.Context, model.IdempotentResponse) error) *Repository_SaveIdempotentResponse_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// StatusHistory provides a mock function with given fields: ctx, orderID
+func (_m *Repository) StatusHistory(ctx context.Context, orderID uuid.UUID) ([]model.StatusChange, error) {
+	ret := _m.Called(ctx, orderID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for StatusHistory")
+	}
+
+	var r0 []model.StatusChange
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) ([]model.StatusChange, error)); ok {
+		return rf(ctx, orderID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) []model.StatusChange); ok {
+		r0 = rf(ctx, orderID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.StatusChange)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, orderID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Repository_StatusHistory_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'StatusHistory'
+type Repository_StatusHistory_Call struct {
+	*mock.Call
+}
+
+// StatusHistory is a helper method to define mock.On call
+//   - ctx context.Context
+//   - orderID uuid.UUID
+func (_e *Repository_Expecter) StatusHistory(ctx interface{}, orderID interface{}) *Repository_StatusHistory_Call {
+	return &Repository_StatusHistory_Call{Call: _e.mock.On("StatusHistory", ctx, orderID)}
+}
+
+func (_c *Repository_StatusHistory_Call) Run(run func(ctx context.Context, orderID uuid.UUID)) *Repository_StatusHistory_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uuid.UUID))
+	})
+	return _c
+}
+
+func (_c *Repository_StatusHistory_Call) Return(_a0 []model.StatusChange, _a1 error) *Repository_StatusHistory_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Repository_StatusHistory_Call) RunAndReturn(run func(context.Context, uuid.UUID) ([]model.StatusChange, error)) *Repository_StatusHistory_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateItemStatus provides a mock function with given fields: ctx, orderID, rid, status, version
+func (_m *Repository) UpdateItemStatus(ctx context.Context, orderID uuid.UUID, rid uuid.UUID, status model.ItemStatus, version int64) error {
+	ret := _m.Called(ctx, orderID, rid, status, version)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateItemStatus")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, uuid.UUID, model.ItemStatus, int64) error); ok {
+		r0 = rf(ctx, orderID, rid, status, version)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Repository_UpdateItemStatus_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateItemStatus'
+type Repository_UpdateItemStatus_Call struct {
+	*mock.Call
+}
+
+// UpdateItemStatus is a helper method to define mock.On call
+//   - ctx context.Context
+//   - orderID uuid.UUID
+//   - rid uuid.UUID
+//   - status model.ItemStatus
+//   - version int64
+func (_e *Repository_Expecter) UpdateItemStatus(ctx interface{}, orderID interface{}, rid interface{}, status interface{}, version interface{}) *Repository_UpdateItemStatus_Call {
+	return &Repository_UpdateItemStatus_Call{Call: _e.mock.On("UpdateItemStatus", ctx, orderID, rid, status, version)}
+}
+
+func (_c *Repository_UpdateItemStatus_Call) Run(run func(ctx context.Context, orderID uuid.UUID, rid uuid.UUID, status model.ItemStatus, version int64)) *Repository_UpdateItemStatus_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uuid.UUID), args[2].(uuid.UUID), args[3].(model.ItemStatus), args[4].(int64))
+	})
+	return _c
+}
+
+func (_c *Repository_UpdateItemStatus_Call) Return(_a0 error) *Repository_UpdateItemStatus_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Repository_UpdateItemStatus_Call) RunAndReturn(run func(context.Context, uuid.UUID, uuid.UUID, model.ItemStatus, int64) error) *Repository_UpdateItemStatus_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewRepository creates a new instance of Repository. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewRepository(t interface {
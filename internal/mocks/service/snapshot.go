@@ -0,0 +1,87 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mockservice
+
+import mock "github.com/stretchr/testify/mock"
+
+// Snapshot is an autogenerated mock type for the Snapshot type
+type Snapshot struct {
+	mock.Mock
+}
+
+type Snapshot_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *Snapshot) EXPECT() *Snapshot_Expecter {
+	return &Snapshot_Expecter{mock: &_m.Mock}
+}
+
+// Restore provides a mock function with no fields
+func (_m *Snapshot) Restore() (int, error) {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Restore")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func() (int, error)); ok {
+		return rf()
+	}
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Snapshot_Restore_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Restore'
+type Snapshot_Restore_Call struct {
+	*mock.Call
+}
+
+// Restore is a helper method to define mock.On call
+func (_e *Snapshot_Expecter) Restore() *Snapshot_Restore_Call {
+	return &Snapshot_Restore_Call{Call: _e.mock.On("Restore")}
+}
+
+func (_c *Snapshot_Restore_Call) Run(run func()) *Snapshot_Restore_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *Snapshot_Restore_Call) Return(_a0 int, _a1 error) *Snapshot_Restore_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Snapshot_Restore_Call) RunAndReturn(run func() (int, error)) *Snapshot_Restore_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewSnapshot creates a new instance of Snapshot. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewSnapshot(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *Snapshot {
+	mock := &Snapshot{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"context"
+)
+
+type sourceKey struct{}
+
+// ContextWithSource attaches the originating Kafka message to ctx so that
+// changes written while handling it can be attributed to it.
+func ContextWithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext returns the source attached by ContextWithSource.
+func SourceFromContext(ctx context.Context) (Source, bool) {
+	source, ok := ctx.Value(sourceKey{}).(Source)
+	return source, ok
+}
@@ -8,8 +8,10 @@ import (
 )
 
 var (
-	ErrOrderNotFound = errors.New("order not found")
-	ErrItemNotFound  = errors.New("item not found")
+	ErrOrderNotFound   = errors.New("order not found")
+	ErrItemNotFound    = errors.New("item not found")
+	ErrVersionConflict = errors.New("item has been changed concurrently")
+	ErrReasonRequired  = errors.New("reason is required")
 )
 
 type ItemStatus string
@@ -63,6 +65,7 @@ type Payment struct {
 	DeliveryCost  int64
 	GoodsTotal    int64
 	CustomFee     int64
+	Refund        int64 // owed back for cancelled and returned items
 }
 
 //type Item struct {
@@ -91,6 +94,7 @@ type Order struct {
 	DeliveryService   string
 	SmID              int64
 	Created           time.Time
+	Revision          int64 // incremented on every change of the order or its items
 
 	Customer Customer
 	Address  Address
@@ -98,23 +102,68 @@ type Order struct {
 }
 
 type OrderFilter struct {
-	OrderID  uuid.UUID
-	IsRecent bool
-	Limit    uint64
+	OrderID         uuid.UUID
+	ItemID          uuid.UUID // the order containing the item
+	CustomerID      uuid.UUID
+	TrackNumber     string
+	DeliveryService string
+	Locale          string
+	ItemStatus      ItemStatus
+	ItemStatuses    []ItemStatus // any item in one of the statuses
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	TouchedFrom     time.Time // created or an item status changed since
+	After           *OrderCursor
+	IsRecent        bool
+	Limit           uint64
+}
+
+// OrderCursor is a keyset position in the (created, id) descending order
+// used for order listing.
+type OrderCursor struct {
+	Created time.Time
+	ID      uuid.UUID
+}
+
+type OrderPage struct {
+	Orders []Order
+	Next   *OrderCursor
 }
 
 type OrderItem struct {
-	ID         uuid.UUID
-	OrderID    uuid.UUID
-	Item       Item
-	ChrtID     int64
-	Price      int64
-	Sale       int64
-	Size       string
-	Quantity   int64
-	TotalPrice int64
-	Status     ItemStatus
-	Created    time.Time
+	ID            uuid.UUID
+	OrderID       uuid.UUID
+	Item          Item
+	ChrtID        int64
+	Price         int64
+	Sale          int64
+	Size          string
+	Quantity      int64
+	TotalPrice    int64
+	Status        ItemStatus
+	Version       int64 // incremented on every status change
+	StatusChanged time.Time
+	StatusEvent   time.Time // time of the last applied status event, zero before the first one
+	Created       time.Time
+}
+
+// StatusChange is a single entry of an order item's status history.
+type StatusChange struct {
+	ItemID  uuid.UUID
+	OrderID uuid.UUID
+	From    ItemStatus
+	To      ItemStatus
+	Source  Source
+	Reason  string // why the item was cancelled or returned
+	Changed time.Time
+}
+
+// Source identifies the Kafka message that caused a change. It is empty for
+// changes that did not come from Kafka.
+type Source struct {
+	Topic     string
+	Partition int32
+	Offset    int64
 }
 
 type Size struct {
@@ -129,3 +178,57 @@ type Size struct {
 //	Value    string
 //	IsCommon bool
 //}
+
+// ItemsChange moves several items of an order to Status at once, as when
+// the order is cancelled or items are returned. Items carry the versions the
+// change was decided on.
+type ItemsChange struct {
+	OrderID   uuid.UUID
+	Items     []OrderItem
+	Status    ItemStatus
+	Reason    string
+	EventTime time.Time // time of the status event causing the change, zero if there is none
+}
+
+// StatusEvent reports a status change of a single order item, as published
+// by logistics. Time is when the change happened and orders the events of an
+// item.
+type StatusEvent struct {
+	ItemID uuid.UUID
+	Status ItemStatus
+	Time   time.Time
+}
+
+// Event types written to the outbox.
+const (
+	EventOrderCreated      = "OrderCreated"
+	EventItemStatusChanged = "ItemStatusChanged"
+)
+
+// OutboxEvent is a domain event stored in the same transaction as the change
+// it reports and published to Kafka afterwards. Events of an order share the
+// order ID as the message key.
+type OutboxEvent struct {
+	ID       uuid.UUID
+	Type     string
+	OrderID  uuid.UUID
+	Payload  []byte // JSON
+	Attempts int    // publishing attempts made, including the current one
+	Created  time.Time
+}
+
+// IdempotentResponse is the stored outcome of a request made with an
+// Idempotency-Key; retries of the request get it replayed.
+type IdempotentResponse struct {
+	Key         string
+	RequestHash string
+	StatusCode  int // zero while the request is being processed
+	Body        []byte
+	Created     time.Time
+}
+
+// Pending reports whether the request that reserved the key has not
+// completed yet.
+func (r IdempotentResponse) Pending() bool {
+	return r.StatusCode == 0
+}
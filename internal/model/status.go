@@ -0,0 +1,125 @@
+package model
+
+import (
+	"slices"
+
+	"github.com/cockroachdb/errors"
+)
+
+var ErrInvalidTransition = errors.New("invalid item status transition")
+
+// transitions lists the statuses an item may move to from each status.
+// Cancellation is possible until the item is handed to delivery; only a
+// delivered item can be returned. Cancelled and returned are final.
+var transitions = map[ItemStatus][]ItemStatus{
+	Pending:    {Processing, Cancelled},
+	Processing: {Assembling, Cancelled},
+	Assembling: {InTransit, Cancelled},
+	InTransit:  {Delivered},
+	Delivered:  {Returned},
+	Cancelled:  {},
+	Returned:   {},
+}
+
+// Valid reports whether s is a known item status.
+func (s ItemStatus) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Final reports whether no further transitions are possible from s.
+func (s ItemStatus) Final() bool {
+	return s.Valid() && len(transitions[s]) == 0
+}
+
+// Refunded reports whether an item in status s is no longer paid for.
+func (s ItemStatus) Refunded() bool {
+	return s == Cancelled || s == Returned
+}
+
+// Unfinished returns the statuses of items still on their way to the
+// customer, sorted: neither final nor delivered. A delivered item can still
+// be returned, but most never are.
+func Unfinished() []ItemStatus {
+	var statuses []ItemStatus
+	for s := range transitions {
+		if !s.Final() && s != Delivered {
+			statuses = append(statuses, s)
+		}
+	}
+	slices.Sort(statuses)
+
+	return statuses
+}
+
+// CanTransitionTo reports whether an item in status s may move to next.
+// Staying in the same status is allowed so that redelivered messages are
+// idempotent.
+func (s ItemStatus) CanTransitionTo(next ItemStatus) bool {
+	if !s.Valid() || !next.Valid() {
+		return false
+	}
+	if s == next {
+		return true
+	}
+
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowedFrom returns the statuses an item may move to s from, including s
+// itself, sorted. It is empty for an unknown status.
+func (s ItemStatus) AllowedFrom() []ItemStatus {
+	var statuses []ItemStatus
+	for from := range transitions {
+		if from.CanTransitionTo(s) {
+			statuses = append(statuses, from)
+		}
+	}
+	slices.Sort(statuses)
+
+	return statuses
+}
+
+// CanReach reports whether an item in status s may get to next through one
+// or more transitions. Status events that arrive out of order skip the
+// statuses in between.
+func (s ItemStatus) CanReach(next ItemStatus) bool {
+	if !s.Valid() || !next.Valid() {
+		return false
+	}
+
+	visited := map[ItemStatus]bool{s: true}
+	queue := []ItemStatus{s}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, allowed := range transitions[current] {
+			if allowed == next {
+				return true
+			}
+			if !visited[allowed] {
+				visited[allowed] = true
+				queue = append(queue, allowed)
+			}
+		}
+	}
+
+	return false
+}
+
+// Transition returns next if the move from s is allowed and
+// ErrInvalidTransition otherwise.
+func (s ItemStatus) Transition(next ItemStatus) (ItemStatus, error) {
+	if !s.CanTransitionTo(next) {
+		return s, errors.WithDetailf(ErrInvalidTransition, "%q -> %q", s, next)
+	}
+
+	return next, nil
+}
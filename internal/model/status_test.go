@@ -0,0 +1,93 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"order_service/internal/model"
+)
+
+func TestItemStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to model.ItemStatus
+		allowed  bool
+	}{
+		{model.Pending, model.Processing, true},
+		{model.Processing, model.Assembling, true},
+		{model.Assembling, model.InTransit, true},
+		{model.InTransit, model.Delivered, true},
+		{model.Delivered, model.Returned, true},
+		{model.Pending, model.Cancelled, true},
+		{model.Assembling, model.Cancelled, true},
+		{model.Delivered, model.Delivered, true},
+
+		{model.Delivered, model.Pending, false},
+		{model.Pending, model.Delivered, false},
+		{model.InTransit, model.Cancelled, false},
+		{model.Pending, model.Returned, false},
+		{model.Cancelled, model.Pending, false},
+		{model.Returned, model.Delivered, false},
+		{model.Pending, "", false},
+		{"", model.Pending, false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.allowed, tt.from.CanTransitionTo(tt.to), "%s -> %s", tt.from, tt.to)
+	}
+}
+
+func TestItemStatus_Transition(t *testing.T) {
+	status, err := model.Processing.Transition(model.Assembling)
+	require.NoError(t, err)
+	require.Equal(t, model.Assembling, status)
+
+	status, err = model.Delivered.Transition(model.Pending)
+	require.ErrorIs(t, err, model.ErrInvalidTransition)
+	require.Equal(t, model.Delivered, status)
+}
+
+func TestItemStatus_CanReach(t *testing.T) {
+	tests := []struct {
+		from, to model.ItemStatus
+		reached  bool
+	}{
+		{model.Pending, model.Processing, true},
+		{model.Pending, model.Delivered, true},
+		{model.Processing, model.Returned, true},
+		{model.Assembling, model.Cancelled, true},
+
+		{model.Pending, model.Pending, false},
+		{model.Delivered, model.Assembling, false},
+		{model.InTransit, model.Cancelled, false},
+		{model.Cancelled, model.Delivered, false},
+		{model.Pending, "", false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.reached, tt.from.CanReach(tt.to), "%s -> %s", tt.from, tt.to)
+	}
+}
+
+func TestItemStatus_AllowedFrom(t *testing.T) {
+	require.Equal(t, []model.ItemStatus{model.Pending}, model.Pending.AllowedFrom())
+	require.Equal(t, []model.ItemStatus{model.Assembling, model.Cancelled, model.Pending, model.Processing},
+		model.Cancelled.AllowedFrom())
+	require.Equal(t, []model.ItemStatus{model.Delivered, model.Returned}, model.Returned.AllowedFrom())
+	require.Empty(t, model.ItemStatus("").AllowedFrom())
+}
+
+func TestItemStatus_Final(t *testing.T) {
+	require.True(t, model.Cancelled.Final())
+	require.True(t, model.Returned.Final())
+	require.False(t, model.Delivered.Final())
+	require.False(t, model.ItemStatus("unknown").Final())
+}
+
+func TestUnfinished(t *testing.T) {
+	require.Equal(t, []model.ItemStatus{
+		model.Assembling,
+		model.InTransit,
+		model.Pending,
+		model.Processing,
+	}, model.Unfinished())
+}
@@ -0,0 +1,182 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"order_service/internal/metrics"
+	"order_service/internal/model"
+)
+
+const (
+	headerEventID   = "event-id"
+	headerEventType = "event-type"
+)
+
+// Store is the outbox table the relay reads from.
+type Store interface {
+	ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error)
+	DeleteEvents(ctx context.Context, ids []uuid.UUID) error
+	ReleaseEvents(ctx context.Context, ids []uuid.UUID, retryAfter time.Duration, cause string) error
+}
+
+// Config tunes the relay. Lease is how long claimed events stay hidden from
+// other relays; it must exceed the time needed to publish a batch, or the
+// batch may be published twice. Failed events are retried with exponential
+// backoff from InitialBackoff up to MaxBackoff.
+type Config struct {
+	Topic          string
+	Interval       time.Duration
+	BatchSize      int
+	Lease          time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Relay publishes outbox events to Kafka. Delivery is at least once: an
+// event is deleted only after Kafka has acknowledged it, so a crash or a
+// failed delete publishes it again, and consumers are expected to
+// deduplicate by the event-id header. The order ID is the message key, so
+// the events of an order keep their order within a partition.
+type Relay struct {
+	store    Store
+	producer sarama.SyncProducer
+	config   Config
+}
+
+func New(store Store, producer sarama.SyncProducer, config Config) *Relay {
+	return &Relay{
+		store:    store,
+		producer: producer,
+		config:   config,
+	}
+}
+
+// NewProducer returns a producer that waits for all in-sync replicas to
+// acknowledge a message, as the relay deletes events once they are sent.
+func NewProducer(brokers []string) (sarama.SyncProducer, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return producer, nil
+}
+
+// Run publishes events until ctx is done. Full batches are followed by the
+// next one right away; otherwise the relay waits for Interval.
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		full, err := r.publishBatch(ctx)
+		if err != nil {
+			log.Error().Stack().Err(err).Msg("Failed to publish outbox events")
+		}
+
+		if err == nil && full {
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishBatch claims a batch and publishes it event by event. The first
+// event Kafka does not accept is released for a retry together with the rest
+// of the batch, so that later events of its order are not published ahead
+// of it. It reports whether the batch was full and published completely.
+func (r *Relay) publishBatch(ctx context.Context) (bool, error) {
+	events, err := r.store.ClaimEvents(ctx, r.config.BatchSize, r.config.Lease)
+	if err != nil {
+		return false, err
+	}
+
+	published := make([]uuid.UUID, 0, len(events))
+	var sendErr error
+	for _, event := range events {
+		_, _, sendErr = r.producer.SendMessage(r.message(event))
+		if sendErr != nil {
+			break
+		}
+		published = append(published, event.ID)
+	}
+
+	if len(published) > 0 {
+		metrics.OutboxEventsPublished.Add(float64(len(published)))
+
+		err = r.store.DeleteEvents(ctx, published)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if sendErr != nil {
+		failed := events[len(published)]
+		retryAfter := r.backoff(failed.Attempts)
+
+		pending := make([]uuid.UUID, 0, len(events)-len(published))
+		for _, event := range events[len(published):] {
+			pending = append(pending, event.ID)
+		}
+
+		metrics.OutboxPublishFailures.Inc()
+		log.Warn().Err(sendErr).
+			Str("event_id", failed.ID.String()).
+			Str("event_type", failed.Type).
+			Int("attempts", failed.Attempts).
+			Dur("retry_after", retryAfter).
+			Msg("Outbox event not published, will retry")
+
+		err = r.store.ReleaseEvents(ctx, pending, retryAfter, sendErr.Error())
+		if err != nil {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	return len(events) == r.config.BatchSize, nil
+}
+
+func (r *Relay) message(event model.OutboxEvent) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: r.config.Topic,
+		Key:   sarama.StringEncoder(event.OrderID.String()),
+		Value: sarama.ByteEncoder(event.Payload),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(headerEventID), Value: []byte(event.ID.String())},
+			{Key: []byte(headerEventType), Value: []byte(event.Type)},
+		},
+	}
+}
+
+// backoff returns the delay before the next attempt after attempts failed
+// ones.
+func (r *Relay) backoff(attempts int) time.Duration {
+	backoff := r.config.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if r.config.MaxBackoff > 0 && backoff >= r.config.MaxBackoff {
+			return r.config.MaxBackoff
+		}
+	}
+
+	return backoff
+}
@@ -0,0 +1,207 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/model"
+)
+
+type fakeStore struct {
+	mu         sync.Mutex
+	events     []model.OutboxEvent
+	limit      int
+	deleted    []uuid.UUID
+	released   []uuid.UUID
+	retryAfter time.Duration
+	cause      string
+}
+
+func (s *fakeStore) ClaimEvents(_ context.Context, limit int, _ time.Duration) ([]model.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = limit
+	n := min(limit, len(s.events))
+	claimed := s.events[:n]
+	s.events = s.events[n:]
+
+	return claimed, nil
+}
+
+func (s *fakeStore) DeleteEvents(_ context.Context, ids []uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleted = append(s.deleted, ids...)
+	return nil
+}
+
+func (s *fakeStore) ReleaseEvents(_ context.Context, ids []uuid.UUID, retryAfter time.Duration, cause string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.released = append(s.released, ids...)
+	s.retryAfter = retryAfter
+	s.cause = cause
+	return nil
+}
+
+func (s *fakeStore) deletedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.deleted)
+}
+
+func testEvents(n int) []model.OutboxEvent {
+	orderID := uuid.New()
+
+	events := make([]model.OutboxEvent, n)
+	for i := range events {
+		events[i] = model.OutboxEvent{
+			ID:       uuid.New(),
+			Type:     model.EventItemStatusChanged,
+			OrderID:  orderID,
+			Payload:  []byte(`{"status":"assembling"}`),
+			Attempts: 1,
+		}
+	}
+	events[0].Type = model.EventOrderCreated
+
+	return events
+}
+
+func ids(events []model.OutboxEvent) []uuid.UUID {
+	ids := make([]uuid.UUID, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+
+	return ids
+}
+
+func TestRelay_PublishBatch(t *testing.T) {
+	events := testEvents(2)
+	store := &fakeStore{events: events}
+	producer := mocks.NewSyncProducer(t, nil)
+	relay := New(store, producer, Config{Topic: "wb-order-events", BatchSize: 2})
+
+	for _, event := range events {
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			require.Equal(t, "wb-order-events", msg.Topic)
+
+			key, err := msg.Key.Encode()
+			require.NoError(t, err)
+			require.Equal(t, event.OrderID.String(), string(key))
+
+			value, err := msg.Value.Encode()
+			require.NoError(t, err)
+			require.Equal(t, event.Payload, value)
+
+			headers := make(map[string]string, len(msg.Headers))
+			for _, h := range msg.Headers {
+				headers[string(h.Key)] = string(h.Value)
+			}
+			require.Equal(t, event.ID.String(), headers[headerEventID])
+			require.Equal(t, event.Type, headers[headerEventType])
+
+			return nil
+		})
+	}
+
+	full, err := relay.publishBatch(context.Background())
+
+	require.NoError(t, err)
+	require.True(t, full)
+	require.Equal(t, 2, store.limit)
+	require.Equal(t, ids(events), store.deleted)
+	require.Empty(t, store.released)
+	require.NoError(t, producer.Close())
+}
+
+func TestRelay_PublishBatch_Failure(t *testing.T) {
+	events := testEvents(3)
+	events[1].Attempts = 3
+	store := &fakeStore{events: events}
+	producer := mocks.NewSyncProducer(t, nil)
+	relay := New(store, producer, Config{
+		Topic:          "wb-order-events",
+		BatchSize:      10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+	})
+
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+
+	full, err := relay.publishBatch(context.Background())
+
+	// The event after the failed one is held back to keep the order of events.
+	require.NoError(t, err)
+	require.False(t, full)
+	require.Equal(t, ids(events[:1]), store.deleted)
+	require.Equal(t, ids(events[1:]), store.released)
+	require.Equal(t, 4*time.Second, store.retryAfter)
+	require.Contains(t, store.cause, sarama.ErrNotEnoughReplicas.Error())
+	require.NoError(t, producer.Close())
+}
+
+func TestRelay_Run(t *testing.T) {
+	events := testEvents(5)
+	store := &fakeStore{events: events}
+	producer := mocks.NewSyncProducer(t, nil)
+	relay := New(store, producer, Config{Topic: "wb-order-events", BatchSize: 2, Interval: time.Hour})
+
+	for range events {
+		producer.ExpectSendMessageAndSucceed()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- relay.Run(ctx) }()
+
+	// Full batches are drained without waiting for the interval.
+	require.Eventually(t, func() bool {
+		return store.deletedCount() == len(events)
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	require.NoError(t, <-done)
+	require.Equal(t, ids(events), store.deleted)
+	require.NoError(t, producer.Close())
+}
+
+func TestRelay_Backoff(t *testing.T) {
+	relay := New(nil, nil, Config{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	require.Equal(t, time.Second, relay.backoff(1))
+	require.Equal(t, 2*time.Second, relay.backoff(2))
+	require.Equal(t, 8*time.Second, relay.backoff(4))
+	require.Equal(t, 10*time.Second, relay.backoff(5))
+}
+
+func TestRelay_PublishBatch_StoreError(t *testing.T) {
+	storeErr := errors.New("connection refused")
+	relay := New(&failingStore{err: storeErr}, mocks.NewSyncProducer(t, nil), Config{BatchSize: 10})
+
+	_, err := relay.publishBatch(context.Background())
+
+	require.ErrorIs(t, err, storeErr)
+}
+
+type failingStore struct {
+	fakeStore
+	err error
+}
+
+func (s *failingStore) ClaimEvents(context.Context, int, time.Duration) ([]model.OutboxEvent, error) {
+	return nil, s.err
+}
@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	commandCancelOrder = "cancel_order"
+	commandReturnItems = "return_items"
+)
+
+var (
+	errValidationUnknownCommand = errors.New("validation failed: unknown command")
+	errValidationNoReason       = errors.New("validation failed: reason is empty")
+)
+
+// commandMessage is a request to change an existing order, published to the
+// command topic. Rids apply to return_items only; none means every item.
+type commandMessage struct {
+	Command  string      `json:"command"`
+	OrderUID uuid.UUID   `json:"order_uid"`
+	Rids     []uuid.UUID `json:"rids"`
+	Reason   string      `json:"reason"`
+}
+
+func (h consumerGroupHandler) processCommandMessage(ctx context.Context, message []byte) error {
+	var cmd commandMessage
+	err := json.Unmarshal(message, &cmd)
+	if err != nil {
+		return errors.Mark(errors.WithStack(err), ErrDecode)
+	}
+
+	err = validateCommand(cmd)
+	if err != nil {
+		return errors.Mark(errors.WithStack(err), ErrValidation)
+	}
+
+	switch cmd.Command {
+	case commandCancelOrder:
+		_, err = h.service.CancelOrder(ctx, cmd.OrderUID, cmd.Reason)
+	case commandReturnItems:
+		_, err = h.service.ReturnItems(ctx, cmd.OrderUID, cmd.Rids, cmd.Reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("command", cmd.Command).Str("order_id", cmd.OrderUID.String()).Msg("Order command applied")
+	return nil
+}
+
+func validateCommand(cmd commandMessage) error {
+	if cmd.Command != commandCancelOrder && cmd.Command != commandReturnItems {
+		return errors.WithDetailf(errValidationUnknownCommand, "%q", cmd.Command)
+	}
+
+	if cmd.OrderUID == uuid.Nil {
+		return errValidationOrderUIDEmpty
+	}
+
+	if cmd.Reason == "" {
+		return errValidationNoReason
+	}
+
+	return nil
+}
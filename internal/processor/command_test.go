@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/model"
+)
+
+func TestProcessWithRetry_Commands(t *testing.T) {
+	svc := &fakeService{}
+	h := consumerGroupHandler{service: svc, topics: Topics{Commands: "wb-order-commands"}}
+
+	_, err := h.processWithRetry(context.Background(), "wb-order-commands", []byte(`{
+		"command": "cancel_order",
+		"order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d",
+		"reason": "changed my mind"
+	}`))
+	require.NoError(t, err)
+
+	_, err = h.processWithRetry(context.Background(), "wb-order-commands", []byte(`{
+		"command": "return_items",
+		"order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d",
+		"rids": ["ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3"],
+		"reason": "wrong size"
+	}`))
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"cancel b563feb7-b2b8-4b6e-9146-d646fd26fb0d changed my mind",
+		"return b563feb7-b2b8-4b6e-9146-d646fd26fb0d [ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3] wrong size",
+	}, svc.commands)
+}
+
+func TestProcessCommandMessage_ErrorClass(t *testing.T) {
+	tests := map[string]struct {
+		message  string
+		expected string
+	}{
+		"not json": {
+			message:  `{not json`,
+			expected: classDecode,
+		},
+		"unknown command": {
+			message:  `{"command": "refund", "order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d", "reason": "x"}`,
+			expected: classValidation,
+		},
+		"no order": {
+			message:  `{"command": "cancel_order", "reason": "x"}`,
+			expected: classValidation,
+		},
+		"no reason": {
+			message:  `{"command": "cancel_order", "order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d"}`,
+			expected: classValidation,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			svc := &fakeService{}
+			h := consumerGroupHandler{service: svc}
+
+			err := h.processCommandMessage(context.Background(), []byte(tt.message))
+
+			require.Error(t, err)
+			require.Equal(t, tt.expected, errorClass(err))
+			require.Zero(t, svc.calls)
+		})
+	}
+}
+
+func TestProcessWithRetry_RejectedCommand(t *testing.T) {
+	svc := &fakeService{errs: []error{errors.WithStack(model.ErrInvalidTransition)}}
+	h := consumerGroupHandler{
+		service: svc,
+		topics:  Topics{Commands: "wb-order-commands"},
+		retry:   RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond},
+	}
+
+	attempts, err := h.processWithRetry(context.Background(), "wb-order-commands", []byte(`{
+		"command": "cancel_order",
+		"order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d",
+		"reason": "changed my mind"
+	}`))
+
+	require.ErrorIs(t, err, model.ErrInvalidTransition)
+	require.Equal(t, classRejected, errorClass(err))
+	require.Equal(t, 1, attempts)
+}
+
+func TestProcessWithRetry_UnknownOrder(t *testing.T) {
+	notFound := errors.WithStack(model.ErrOrderNotFound)
+	svc := &fakeService{errs: []error{notFound, notFound, notFound, notFound}}
+	h := consumerGroupHandler{
+		service: svc,
+		topics:  Topics{Commands: "wb-order-commands"},
+		// Unlimited retries still give up on an unknown order.
+		retry: RetryPolicy{UnknownOrderAttempts: 2, InitialBackoff: time.Millisecond},
+	}
+
+	attempts, err := h.processWithRetry(context.Background(), "wb-order-commands", []byte(`{
+		"command": "cancel_order",
+		"order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d",
+		"reason": "changed my mind"
+	}`))
+
+	require.ErrorIs(t, err, model.ErrOrderNotFound)
+	require.Equal(t, classUnknownOrder, errorClass(err))
+	require.Equal(t, 2, attempts)
+	require.Equal(t, 2, svc.calls)
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	headerErrorClass        = "x-error-class"
+	headerError             = "x-error"
+	headerOriginalTopic     = "x-original-topic"
+	headerOriginalPartition = "x-original-partition"
+	headerOriginalOffset    = "x-original-offset"
+	headerAttempts          = "x-attempts"
+)
+
+// deadLetter publishes messages that could not be processed to a separate
+// topic, so they can be inspected and replayed later.
+type deadLetter struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func (d *deadLetter) enabled() bool {
+	return d != nil && d.producer != nil && d.topic != ""
+}
+
+func (d *deadLetter) publish(message *sarama.ConsumerMessage, cause error, attempts int) error {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+6)
+	for _, h := range message.Headers {
+		if h != nil {
+			headers = append(headers, *h)
+		}
+	}
+
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(headerErrorClass), Value: []byte(errorClass(cause))},
+		sarama.RecordHeader{Key: []byte(headerError), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(headerOriginalTopic), Value: []byte(message.Topic)},
+		sarama.RecordHeader{Key: []byte(headerOriginalPartition), Value: []byte(strconv.FormatInt(int64(message.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(headerOriginalOffset), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(headerAttempts), Value: []byte(strconv.Itoa(attempts))},
+	)
+
+	msg := &sarama.ProducerMessage{
+		Topic:   d.topic,
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if message.Key != nil {
+		msg.Key = sarama.ByteEncoder(message.Key)
+	}
+
+	_, _, err := d.producer.SendMessage(msg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (d *deadLetter) close() error {
+	if d == nil || d.producer == nil {
+		return nil
+	}
+
+	return d.producer.Close()
+}
@@ -3,47 +3,112 @@ package processor
 import (
 	"context"
 	"encoding/json"
+	"slices"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"order_service/internal/metrics"
 	"order_service/internal/model"
+	"order_service/internal/tracing"
 )
 
+var tracer = otel.Tracer("order_service/internal/processor")
+
 var (
 	errValidationOrderUIDEmpty = errors.New("validation failed: order_uid is emptyr")
 	errValidationNoItems       = errors.New("validation failed: order contains no items")
 	errValidationNoValidItems  = errors.New("validation failed: order contains no valid items")
+
+	errNoSession         = errors.New("kafka consumer has no active group session")
+	errMessageNotHandled = errors.New("message neither processed nor dead-lettered")
+
+	// ErrDecode and ErrValidation mark orders rejected by DecodeOrder.
+	ErrDecode     = errors.New("message decoding failed")
+	ErrValidation = errors.New("message validation failed")
 )
 
 type Service interface {
 	ProcessOrder(ctx context.Context, order model.Order) error
+	CancelOrder(ctx context.Context, orderID uuid.UUID, reason string) (model.Order, error)
+	ReturnItems(ctx context.Context, orderID uuid.UUID, rids []uuid.UUID, reason string) (model.Order, error)
+	ApplyStatusEvent(ctx context.Context, event model.StatusEvent) error
+}
+
+// Topics lists the topics OrderProcessor works with. Messages are told apart
+// by the topic they come from; an empty Commands, StatusEvents or DeadLetter
+// disables the respective messages.
+type Topics struct {
+	Orders       []string
+	Commands     string
+	StatusEvents string
+	DeadLetter   string
+}
+
+func (t Topics) consumed() []string {
+	topics := slices.Clip(t.Orders)
+	if t.Commands != "" {
+		topics = append(topics, t.Commands)
+	}
+	if t.StatusEvents != "" {
+		topics = append(topics, t.StatusEvents)
+	}
+
+	return topics
 }
 
 type OrderProcessor struct {
-	group   sarama.ConsumerGroup
-	service Service
-	topics  []string
+	group      sarama.ConsumerGroup
+	service    Service
+	topics     Topics
+	deadLetter *deadLetter
+	retry      RetryPolicy
+	active     atomic.Bool
 }
 
 type consumerGroupHandler struct {
-	service Service
+	service    Service
+	topics     Topics
+	deadLetter *deadLetter
+	retry      RetryPolicy
+	active     *atomic.Bool
 }
 
-func New(brokers []string, topics []string, groupID string, service Service) *OrderProcessor {
+// New consumes orders, order commands and item status events from topics.
+// All of them share the retry policy and the dead-letter topic.
+func New(brokers []string, topics Topics, groupID string, retry RetryPolicy, service Service) *OrderProcessor {
 	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
 
 	group, err := sarama.NewConsumerGroup(brokers, groupID, config)
 	if err != nil {
 		log.Error().Stack().Err(err).Send()
 	}
 
+	var dl *deadLetter
+	if topics.DeadLetter != "" {
+		producer, err := sarama.NewSyncProducer(brokers, config)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+		}
+		dl = &deadLetter{producer: producer, topic: topics.DeadLetter}
+	}
+
 	return &OrderProcessor{
-		group:   group,
-		topics:  topics,
-		service: service,
+		group:      group,
+		topics:     topics,
+		service:    service,
+		deadLetter: dl,
+		retry:      retry,
 	}
 }
 
@@ -52,15 +117,23 @@ func (p *OrderProcessor) Start(ctx context.Context) error {
 	log.Info().Msg("Starting Kafka consumer...")
 
 	handler := &consumerGroupHandler{
-		service: p.service,
+		service:    p.service,
+		topics:     p.topics,
+		deadLetter: p.deadLetter,
+		retry:      p.retry,
+		active:     &p.active,
 	}
 
+	topics := p.topics.consumed()
 	for {
-		if err := ctx.Err(); err != nil {
-			return errors.WithStack(err)
+		if ctx.Err() != nil {
+			return nil
 		}
 
-		err := p.group.Consume(ctx, p.topics, handler)
+		err := p.group.Consume(ctx, topics, handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return nil
+		}
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -70,14 +143,30 @@ func (p *OrderProcessor) Start(ctx context.Context) error {
 func (p *OrderProcessor) Stop() error {
 	log.Info().Msg("Closing Kafka consumer...")
 
-	return p.group.Close()
+	err := p.group.Close()
+	if dlErr := p.deadLetter.close(); dlErr != nil {
+		err = errors.CombineErrors(err, dlErr)
+	}
+
+	return err
+}
+
+// Check reports whether the consumer currently holds a group session.
+func (p *OrderProcessor) Check(_ context.Context) error {
+	if !p.active.Load() {
+		return errNoSession
+	}
+
+	return nil
 }
 
 func (h consumerGroupHandler) Setup(_ sarama.ConsumerGroupSession) error {
+	h.active.Store(true)
 	return nil
 }
 
 func (h consumerGroupHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
+	h.active.Store(false)
 	return nil
 }
 
@@ -86,9 +175,21 @@ func (h consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 		log.Info().Msgf("Received message from topic %s, partition %d, offset %d",
 			message.Topic, message.Partition, message.Offset)
 
-		if err := h.processOrderMessage(session.Context(), message.Value); err != nil {
-			log.Error().Stack().Err(err).Send()
-			continue
+		metrics.ConsumerLag.WithLabelValues(message.Topic, strconv.FormatInt(int64(message.Partition), 10)).
+			Set(float64(claim.HighWaterMarkOffset() - message.Offset - 1))
+
+		if !h.handleMessage(session.Context(), message) {
+			if session.Context().Err() != nil {
+				// The session is ending; leave the offset unmarked so the
+				// message is redelivered after the rebalance.
+				return nil
+			}
+			// The dead-letter topic is unavailable even after retries.
+			// Marking a later message would commit past this one, so the
+			// claim is stopped instead and the partition is consumed again
+			// from this message once the session is rejoined.
+			return errors.Wrapf(errMessageNotHandled, "topic %s, partition %d, offset %d",
+				message.Topic, message.Partition, message.Offset)
 		}
 
 		session.MarkMessage(message, "")
@@ -97,19 +198,124 @@ func (h consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 	return nil
 }
 
-func (h consumerGroupHandler) processOrderMessage(ctx context.Context, message []byte) error {
-	var msg orderMessage
-	err := json.Unmarshal(message, &msg)
-	if err != nil {
-		return errors.WithStack(err)
+// handleMessage processes a single message within a consumer span continuing
+// the producer's trace. It reports whether the offset may be marked.
+func (h consumerGroupHandler) handleMessage(ctx context.Context, message *sarama.ConsumerMessage) bool {
+	topic, partition := message.Topic, strconv.FormatInt(int64(message.Partition), 10)
+	metrics.MessagesConsumed.WithLabelValues(topic, partition).Inc()
+
+	ctx = otel.GetTextMapPropagator().Extract(ctx, tracing.ConsumerHeaders(message.Headers))
+	ctx, span := tracer.Start(ctx, topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingDestinationPartitionID(partition),
+			semconv.MessagingKafkaMessageOffset(int(message.Offset)),
+		),
+	)
+	defer span.End()
+
+	ctx = model.ContextWithSource(ctx, model.Source{
+		Topic:     topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+	})
+
+	attempts, err := h.processWithRetry(ctx, topic, message.Value)
+	metrics.MessagesRetried.WithLabelValues(topic, partition).Add(float64(attempts - 1))
+	if err == nil {
+		return true
 	}
 
-	err = validateMessage(msg)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, errorClass(err))
+	if ctx.Err() != nil {
+		return false
+	}
+
+	log.Error().Stack().Err(err).Str("class", errorClass(err)).Int("attempts", attempts).Send()
+	metrics.MessagesFailed.WithLabelValues(topic, partition, errorClass(err)).Inc()
+	if !h.deadLetter.enabled() {
+		// Without a dead-letter topic there is nowhere to set the message
+		// aside, and holding the partition would only redeliver it forever.
+		log.Error().Str("topic", topic).Int32("partition", message.Partition).Int64("offset", message.Offset).
+			Msg("Message dropped, no dead-letter topic configured")
+		return true
+	}
+
+	if err = h.publishDeadLetter(ctx, message, err, attempts); err != nil {
+		log.Error().Stack().Err(err).Msg("failed to publish message to dead-letter topic")
+		return false
+	}
+
+	return true
+}
+
+// publishDeadLetter publishes the message to the dead-letter topic, retrying
+// failures with the retry policy, so that an unavailable topic holds the
+// partition with backoff rather than ending the session at once.
+func (h consumerGroupHandler) publishDeadLetter(ctx context.Context, message *sarama.ConsumerMessage, cause error,
+	attempts int) error {
+	for attempt := 1; ; attempt++ {
+		err := h.deadLetter.publish(message, cause, attempts)
+		if err == nil || !h.retry.allows(attempt, err) {
+			return err
+		}
+
+		backoff := h.retry.backoff(attempt)
+		log.Warn().Err(err).Int("attempt", attempt).Dur("backoff", backoff).
+			Msg("retrying dead-letter publishing")
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// processWithRetry processes the message, retrying transient failures with
+// backoff and blocking the partition meanwhile. It returns the number of
+// attempts made together with the last error.
+func (h consumerGroupHandler) processWithRetry(ctx context.Context, topic string, message []byte) (int, error) {
+	process := h.processor(topic)
+	for attempt := 1; ; attempt++ {
+		err := process(ctx, message)
+		if err == nil || isPermanent(err) || !h.retry.allows(attempt, err) {
+			return attempt, err
+		}
+
+		backoff := h.retry.backoff(attempt)
+		log.Warn().Err(err).Int("attempt", attempt).Dur("backoff", backoff).Msg("retrying message processing")
+
+		select {
+		case <-ctx.Done():
+			return attempt, errors.WithStack(ctx.Err())
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// processor returns the function that processes messages from topic.
+func (h consumerGroupHandler) processor(topic string) func(context.Context, []byte) error {
+	switch {
+	case h.topics.Commands != "" && topic == h.topics.Commands:
+		return h.processCommandMessage
+	case h.topics.StatusEvents != "" && topic == h.topics.StatusEvents:
+		return h.processStatusMessage
+	default:
+		return h.processOrderMessage
+	}
+}
+
+func (h consumerGroupHandler) processOrderMessage(ctx context.Context, message []byte) error {
+	order, err := DecodeOrder(message)
 	if err != nil {
 		return err
 	}
 
-	err = h.service.ProcessOrder(ctx, orderToModel(msg))
+	err = h.service.ProcessOrder(ctx, order)
 	if err != nil {
 		return err
 	}
@@ -117,6 +323,23 @@ func (h consumerGroupHandler) processOrderMessage(ctx context.Context, message [
 	return nil
 }
 
+// DecodeOrder parses and validates an order in the format published to the
+// orders topic. Errors are marked with ErrDecode or ErrValidation.
+func DecodeOrder(data []byte) (model.Order, error) {
+	var msg orderMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return model.Order{}, errors.Mark(errors.WithStack(err), ErrDecode)
+	}
+
+	err = validateMessage(msg)
+	if err != nil {
+		return model.Order{}, errors.Mark(errors.WithStack(err), ErrValidation)
+	}
+
+	return orderToModel(msg), nil
+}
+
 func validateMessage(msg orderMessage) error {
 	if msg.OrderUID == uuid.Nil {
 		return errValidationOrderUIDEmpty
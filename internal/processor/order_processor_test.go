@@ -0,0 +1,285 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"order_service/internal/model"
+)
+
+const validMessage = `{
+	"order_uid": "b563feb7-b2b8-4b6e-9146-d646fd26fb0d",
+	"customer_id": "6c1a5b80-6dd9-4b5a-8c3f-9e0c2e8fd7a1",
+	"items": [{"chrt_id": 9934930, "rid": "ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3"}]
+}`
+
+type fakeService struct {
+	errs     []error
+	calls    int
+	commands []string
+}
+
+func (s *fakeService) ProcessOrder(_ context.Context, _ model.Order) error {
+	return s.call()
+}
+
+func (s *fakeService) CancelOrder(_ context.Context, orderID uuid.UUID, reason string) (model.Order, error) {
+	s.commands = append(s.commands, "cancel "+orderID.String()+" "+reason)
+	return model.Order{}, s.call()
+}
+
+func (s *fakeService) ReturnItems(_ context.Context, orderID uuid.UUID, rids []uuid.UUID,
+	reason string) (model.Order, error) {
+	s.commands = append(s.commands, fmt.Sprintf("return %s %v %s", orderID, rids, reason))
+	return model.Order{}, s.call()
+}
+
+func (s *fakeService) ApplyStatusEvent(_ context.Context, event model.StatusEvent) error {
+	s.commands = append(s.commands, fmt.Sprintf("status %s %s %s", event.ItemID, event.Status,
+		event.Time.Format(time.RFC3339)))
+	return s.call()
+}
+
+func (s *fakeService) call() error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func TestProcessOrderMessage_ErrorClass(t *testing.T) {
+	h := consumerGroupHandler{}
+
+	err := h.processOrderMessage(context.Background(), []byte("{not json"))
+	require.Error(t, err)
+	require.Equal(t, classDecode, errorClass(err))
+
+	err = h.processOrderMessage(context.Background(), []byte(`{"items": []}`))
+	require.ErrorIs(t, err, errValidationOrderUIDEmpty)
+	require.Equal(t, classValidation, errorClass(err))
+}
+
+func TestDeadLetter_Publish(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	dl := &deadLetter{producer: producer, topic: "wb-orders-dlq"}
+
+	message := &sarama.ConsumerMessage{
+		Topic:     "wb-orders",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("{not json"),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}},
+	}
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		require.Equal(t, "wb-orders-dlq", msg.Topic)
+
+		value, err := msg.Value.Encode()
+		require.NoError(t, err)
+		require.Equal(t, message.Value, value)
+
+		headers := make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			headers[string(h.Key)] = string(h.Value)
+		}
+		require.Equal(t, "abc", headers["trace"])
+		require.Equal(t, classDecode, headers[headerErrorClass])
+		require.Equal(t, "wb-orders", headers[headerOriginalTopic])
+		require.Equal(t, "2", headers[headerOriginalPartition])
+		require.Equal(t, "42", headers[headerOriginalOffset])
+		require.Equal(t, "1", headers[headerAttempts])
+
+		return nil
+	})
+
+	err := (consumerGroupHandler{}).processOrderMessage(context.Background(), message.Value)
+	require.NoError(t, dl.publish(message, err, 1))
+	require.NoError(t, producer.Close())
+}
+
+func TestProcessWithRetry_TransientThenSuccess(t *testing.T) {
+	svc := &fakeService{errs: []error{errors.New("connection refused"), errors.New("connection refused")}}
+	h := consumerGroupHandler{
+		service: svc,
+		retry:   RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	attempts, err := h.processWithRetry(context.Background(), "wb-orders", []byte(validMessage))
+
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+	require.Equal(t, 3, svc.calls)
+}
+
+func TestProcessWithRetry_Exhausted(t *testing.T) {
+	svc := &fakeService{errs: []error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout")}}
+	h := consumerGroupHandler{
+		service: svc,
+		retry:   RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	}
+
+	attempts, err := h.processWithRetry(context.Background(), "wb-orders", []byte(validMessage))
+
+	require.Error(t, err)
+	require.Equal(t, classTransient, errorClass(err))
+	require.Equal(t, 2, attempts)
+}
+
+func TestProcessWithRetry_Permanent(t *testing.T) {
+	svc := &fakeService{errs: []error{errors.WithStack(&pgconn.PgError{Code: "23503"})}}
+	h := consumerGroupHandler{
+		service: svc,
+		retry:   RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond},
+	}
+
+	attempts, err := h.processWithRetry(context.Background(), "wb-orders", []byte(validMessage))
+
+	require.Error(t, err)
+	require.Equal(t, classData, errorClass(err))
+	require.Equal(t, 1, attempts)
+
+	attempts, err = h.processWithRetry(context.Background(), "wb-orders", []byte("{not json"))
+
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+	require.Equal(t, 1, svc.calls)
+}
+
+func TestProcessWithRetry_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	h := consumerGroupHandler{
+		service: &fakeService{errs: []error{errors.New("timeout")}},
+		retry:   RetryPolicy{InitialBackoff: time.Hour},
+	}
+
+	_, err := h.processWithRetry(ctx, "wb-orders", []byte(validMessage))
+
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	require.Equal(t, 100*time.Millisecond, p.backoff(1))
+	require.Equal(t, 200*time.Millisecond, p.backoff(2))
+	require.Equal(t, 800*time.Millisecond, p.backoff(4))
+	require.Equal(t, time.Second, p.backoff(5))
+	require.Equal(t, time.Second, p.backoff(50))
+}
+
+func TestHandleMessage_ContinuesTrace(t *testing.T) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	h := consumerGroupHandler{service: &fakeService{}}
+	message := &sarama.ConsumerMessage{
+		Topic: "wb-orders",
+		Value: []byte(validMessage),
+		Headers: []*sarama.RecordHeader{{
+			Key:   []byte("traceparent"),
+			Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		}},
+	}
+
+	require.True(t, h.handleMessage(context.Background(), message))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "wb-orders process", spans[0].Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func (c *fakeClaim) HighWaterMarkOffset() int64 { return int64(cap(c.messages)) }
+
+func TestConsumeClaim_StopsOnUnhandledMessage(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	svc := &fakeService{}
+	h := consumerGroupHandler{
+		service:    svc,
+		deadLetter: &deadLetter{producer: producer, topic: "wb-orders-dlq"},
+		retry:      RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	}
+
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "wb-orders", Offset: 0, Value: []byte(validMessage)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "wb-orders", Offset: 1, Value: []byte("{not json")}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "wb-orders", Offset: 2, Value: []byte(validMessage)}
+	close(claim.messages)
+	session := &fakeSession{ctx: context.Background()}
+
+	err := h.ConsumeClaim(session, claim)
+
+	// The dead-letter topic is retried with backoff first; the message
+	// after the failed one must not commit the offset past it.
+	require.ErrorIs(t, err, errMessageNotHandled)
+	require.Equal(t, []int64{0}, session.marked)
+	require.Equal(t, 1, svc.calls)
+	require.NoError(t, producer.Close())
+}
+
+func TestConsumeClaim_DropsWithoutDeadLetter(t *testing.T) {
+	svc := &fakeService{}
+	h := consumerGroupHandler{service: svc, retry: RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}}
+
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "wb-orders", Offset: 0, Value: []byte(validMessage)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "wb-orders", Offset: 1, Value: []byte("{not json")}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "wb-orders", Offset: 2, Value: []byte(validMessage)}
+	close(claim.messages)
+	session := &fakeSession{ctx: context.Background()}
+
+	err := h.ConsumeClaim(session, claim)
+
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2}, session.marked)
+	require.Equal(t, 2, svc.calls)
+}
@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"order_service/internal/model"
+)
+
+const (
+	classDecode       = "decode"
+	classValidation   = "validation"
+	classData         = "data"
+	classRejected     = "rejected"
+	classUnknownOrder = "unknown_order"
+	classTransient    = "transient"
+)
+
+// DefaultUnknownOrderAttempts bounds the attempts for a message about an
+// unknown order when RetryPolicy.UnknownOrderAttempts is not set.
+const DefaultUnknownOrderAttempts = 3
+
+// errorClass reports why a message failed. Decode, validation, data and
+// rejected errors are permanent: retrying the same payload cannot succeed.
+// A command or status event for an unknown order is retried a few times, as
+// the order itself may not have been consumed yet, and is then dead-lettered
+// instead of blocking the partition.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrDecode):
+		return classDecode
+	case errors.Is(err, ErrValidation):
+		return classValidation
+	case isDataError(err):
+		return classData
+	case isRejected(err):
+		return classRejected
+	case errors.Is(err, model.ErrOrderNotFound):
+		return classUnknownOrder
+	default:
+		return classTransient
+	}
+}
+
+func isPermanent(err error) bool {
+	class := errorClass(err)
+	return class != classTransient && class != classUnknownOrder
+}
+
+// isRejected reports whether the service refused a command under the order
+// business rules.
+func isRejected(err error) bool {
+	return errors.Is(err, model.ErrInvalidTransition) ||
+		errors.Is(err, model.ErrItemNotFound) ||
+		errors.Is(err, model.ErrReasonRequired)
+}
+
+// isDataError reports whether Postgres rejected the data itself (data
+// exceptions and integrity constraint violations).
+func isDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || len(pgErr.Code) < 2 {
+		return false
+	}
+
+	switch pgErr.Code[:2] {
+	case "22", "23":
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryPolicy configures exponential backoff for transient failures.
+// MaxAttempts <= 0 retries until the session ends. Messages for an unknown
+// order get at most UnknownOrderAttempts (DefaultUnknownOrderAttempts if
+// <= 0), even when MaxAttempts is unbounded.
+type RetryPolicy struct {
+	MaxAttempts          int
+	UnknownOrderAttempts int
+	InitialBackoff       time.Duration
+	MaxBackoff           time.Duration
+}
+
+// allows reports whether a message that failed attempt times with err may be
+// retried.
+func (p RetryPolicy) allows(attempt int, err error) bool {
+	if errorClass(err) == classUnknownOrder {
+		limit := p.UnknownOrderAttempts
+		if limit <= 0 {
+			limit = DefaultUnknownOrderAttempts
+		}
+		if attempt >= limit {
+			return false
+		}
+	}
+
+	return p.MaxAttempts <= 0 || attempt < p.MaxAttempts
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return backoff
+}
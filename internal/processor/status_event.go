@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"order_service/internal/model"
+)
+
+var (
+	errValidationRidEmpty       = errors.New("validation failed: rid is empty")
+	errValidationUnknownStatus  = errors.New("validation failed: unknown status code")
+	errValidationTimestampEmpty = errors.New("validation failed: timestamp is empty")
+)
+
+// statusMessage is a status change of a single order item, published by
+// logistics to the status events topic. Status is a code from
+// model.StatusCode.
+type statusMessage struct {
+	Rid       uuid.UUID `json:"rid"`
+	Status    int64     `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (h consumerGroupHandler) processStatusMessage(ctx context.Context, message []byte) error {
+	event, err := DecodeStatusEvent(message)
+	if err != nil {
+		return err
+	}
+
+	return h.service.ApplyStatusEvent(ctx, event)
+}
+
+// DecodeStatusEvent parses and validates an item status event. Errors are
+// marked with ErrDecode or ErrValidation.
+func DecodeStatusEvent(data []byte) (model.StatusEvent, error) {
+	var msg statusMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return model.StatusEvent{}, errors.Mark(errors.WithStack(err), ErrDecode)
+	}
+
+	err = validateStatusMessage(msg)
+	if err != nil {
+		return model.StatusEvent{}, errors.Mark(errors.WithStack(err), ErrValidation)
+	}
+
+	return model.StatusEvent{
+		ItemID: msg.Rid,
+		Status: model.StatusCode[msg.Status],
+		Time:   msg.Timestamp,
+	}, nil
+}
+
+func validateStatusMessage(msg statusMessage) error {
+	if msg.Rid == uuid.Nil {
+		return errValidationRidEmpty
+	}
+
+	if _, ok := model.StatusCode[msg.Status]; !ok {
+		return errors.WithDetailf(errValidationUnknownStatus, "%d", msg.Status)
+	}
+
+	if msg.Timestamp.IsZero() {
+		return errValidationTimestampEmpty
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessWithRetry_StatusEvent(t *testing.T) {
+	svc := &fakeService{}
+	h := consumerGroupHandler{service: svc, topics: Topics{Orders: []string{"wb-orders"}, StatusEvents: "wb-item-status"}}
+
+	_, err := h.processWithRetry(context.Background(), "wb-item-status", []byte(`{
+		"rid": "ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3",
+		"status": 400,
+		"timestamp": "2021-11-26T07:22:19Z"
+	}`))
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"status ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3 in_transit 2021-11-26T07:22:19Z"},
+		svc.commands)
+}
+
+func TestDecodeStatusEvent_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		message  string
+		expected string
+	}{
+		"not json": {
+			message:  `{not json`,
+			expected: classDecode,
+		},
+		"no rid": {
+			message:  `{"status": 400, "timestamp": "2021-11-26T07:22:19Z"}`,
+			expected: classValidation,
+		},
+		"unknown status": {
+			message:  `{"rid": "ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3", "status": 999, "timestamp": "2021-11-26T07:22:19Z"}`,
+			expected: classValidation,
+		},
+		"no timestamp": {
+			message:  `{"rid": "ab421908-7a76-4ae0-b5f1-2d9ec1f0a2b3", "status": 400}`,
+			expected: classValidation,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeStatusEvent([]byte(tt.message))
+
+			require.Error(t, err)
+			require.Equal(t, tt.expected, errorClass(err))
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"order_service/internal/model"
+)
+
+type orderCreatedPayload struct {
+	EventID         uuid.UUID          `json:"event_id"`
+	OrderID         uuid.UUID          `json:"order_id"`
+	TrackNumber     string             `json:"track_number"`
+	CustomerID      uuid.UUID          `json:"customer_id"`
+	DeliveryService string             `json:"delivery_service"`
+	Amount          int64              `json:"amount"`
+	Currency        string             `json:"currency"`
+	Items           []orderCreatedItem `json:"items"`
+	DateCreated     time.Time          `json:"date_created"`
+}
+
+type orderCreatedItem struct {
+	Rid        uuid.UUID `json:"rid"`
+	ChrtID     int64     `json:"chrt_id"`
+	NmID       uuid.UUID `json:"nm_id"`
+	TotalPrice int64     `json:"total_price"`
+	Status     string    `json:"status"`
+}
+
+type itemStatusChangedPayload struct {
+	EventID        uuid.UUID `json:"event_id"`
+	OrderID        uuid.UUID `json:"order_id"`
+	Rid            uuid.UUID `json:"rid"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Status         string    `json:"status"`
+	Reason         string    `json:"reason,omitempty"`
+	ChangedAt      time.Time `json:"changed_at"`
+}
+
+func orderCreatedEvent(order model.Order) (model.OutboxEvent, error) {
+	payload := orderCreatedPayload{
+		EventID:         uuid.New(),
+		OrderID:         order.ID,
+		TrackNumber:     order.TrackNumber,
+		CustomerID:      order.Customer.ID,
+		DeliveryService: order.DeliveryService,
+		Amount:          order.Payment.Amount,
+		Currency:        order.Payment.Currency,
+		Items:           make([]orderCreatedItem, len(order.Items)),
+		DateCreated:     order.Created,
+	}
+	for i, item := range order.Items {
+		payload.Items[i] = orderCreatedItem{
+			Rid:        item.ID,
+			ChrtID:     item.ChrtID,
+			NmID:       item.Item.ID,
+			TotalPrice: item.TotalPrice,
+			Status:     string(item.Status),
+		}
+	}
+
+	return outboxEvent(payload.EventID, model.EventOrderCreated, order.ID, payload)
+}
+
+func statusChangedEvents(changes []model.StatusChange) ([]model.OutboxEvent, error) {
+	events := make([]model.OutboxEvent, 0, len(changes))
+	for _, change := range changes {
+		payload := itemStatusChangedPayload{
+			EventID:        uuid.New(),
+			OrderID:        change.OrderID,
+			Rid:            change.ItemID,
+			PreviousStatus: string(change.From),
+			Status:         string(change.To),
+			Reason:         change.Reason,
+			ChangedAt:      change.Changed,
+		}
+
+		event, err := outboxEvent(payload.EventID, model.EventItemStatusChanged, change.OrderID, payload)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func outboxEvent(id uuid.UUID, eventType string, orderID uuid.UUID, payload any) (model.OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return model.OutboxEvent{}, errors.WithStack(err)
+	}
+
+	return model.OutboxEvent{
+		ID:      id,
+		Type:    eventType,
+		OrderID: orderID,
+		Payload: data,
+	}, nil
+}
+
+// insertOutbox stores events in tx, so that they are published if and only if
+// the change they report is committed.
+func (r *Repository) insertOutbox(ctx context.Context, tx pgx.Tx, events []model.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	insert := r.builder.Insert("outbox").Columns("event_id", "event_type", "order_id", "payload")
+	for _, event := range events {
+		insert = insert.Values(event.ID, event.Type, event.OrderID, event.Payload)
+	}
+
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// claimLockID serializes concurrent ClaimEvents calls through a
+// transaction-level advisory lock.
+const claimLockID = 7_200_310_156
+
+// ClaimEvents returns up to limit unpublished events, oldest first, and hides
+// them from other callers for lease. An event is not claimed while an earlier
+// event of the same order is claimed or waits for a retry, so that the events
+// of an order are published in the order they were written.
+//
+// Claims are serialized: a concurrent claim would not see the leases taken by
+// one still in progress and could pick a later event of an order whose earlier
+// event is locked. Event IDs follow the commit order within an order, as every
+// change locks the order row before writing its events.
+func (r *Repository) ClaimEvents(ctx context.Context, limit int, lease time.Duration) (_ []model.OutboxEvent, err error) {
+	ctx, end := startQuery(ctx, "ClaimEvents")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Taken in its own statement, so that the claim below runs with a snapshot
+	// that includes the leases of the previous claim.
+	_, err = tx.Exec(ctx, `select pg_advisory_xact_lock($1)`, claimLockID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	query := `
+        with claimed as (
+            update outbox o
+            set attempts = o.attempts + 1,
+                next_attempt_at = now() + $2 * interval '1 millisecond'
+            from (
+                select id from outbox e
+                where e.next_attempt_at <= now()
+                  and not exists (
+                      select 1 from outbox p
+                      where p.order_id = e.order_id and p.id < e.id and p.next_attempt_at > now()
+                  )
+                order by e.id
+                limit $1
+                for update skip locked
+            ) c
+            where o.id = c.id
+            returning o.id, o.event_id, o.event_type, o.order_id, o.payload, o.attempts, o.created_at
+        )
+        select event_id, event_type, order_id, payload, attempts, created_at
+        from claimed
+        order by id
+    `
+
+	rows, err := tx.Query(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	eventRows, err := pgx.CollectRows[outboxRow](rows, pgx.RowToStructByNameLax[outboxRow])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	events := make([]model.OutboxEvent, 0, len(eventRows))
+	for _, row := range eventRows {
+		events = append(events, outboxEventModel(row))
+	}
+
+	return events, nil
+}
+
+// DeleteEvents removes published events from the outbox.
+func (r *Repository) DeleteEvents(ctx context.Context, ids []uuid.UUID) (err error) {
+	ctx, end := startQuery(ctx, "DeleteEvents")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	_, err = tx.Exec(ctx, `delete from outbox where event_id = any($1)`, ids)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// ReleaseEvents makes claimed events available again after retryAfter,
+// recording why they were not published.
+func (r *Repository) ReleaseEvents(ctx context.Context, ids []uuid.UUID, retryAfter time.Duration,
+	cause string) (err error) {
+	ctx, end := startQuery(ctx, "ReleaseEvents")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	query := `
+        update outbox
+        set next_attempt_at = now() + $2 * interval '1 millisecond', last_error = $3
+        where event_id = any($1)
+    `
+
+	_, err = tx.Exec(ctx, query, ids, retryAfter.Milliseconds(), cause)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+type outboxRow struct {
+	EventID   uuid.UUID `db:"event_id"`
+	EventType string    `db:"event_type"`
+	OrderID   uuid.UUID `db:"order_id"`
+	Payload   []byte    `db:"payload"`
+	Attempts  int       `db:"attempts"`
+	Created   time.Time `db:"created_at"`
+}
+
+func outboxEventModel(row outboxRow) model.OutboxEvent {
+	return model.OutboxEvent{
+		ID:       row.EventID,
+		Type:     row.EventType,
+		OrderID:  row.OrderID,
+		Payload:  row.Payload,
+		Attempts: row.Attempts,
+		Created:  row.Created,
+	}
+}
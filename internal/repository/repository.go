@@ -2,31 +2,72 @@ package repository
 
 import (
 	"context"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lib/pq"
 	_ "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"order_service/internal/metrics"
 	"order_service/internal/model"
 )
 
+var tracer = otel.Tracer("order_service/internal/repository")
+
+// ChangesChannel is the NOTIFY channel carrying the IDs of orders whose data
+// changed, together with the order revision the change produced (see
+// ParseChange). Notifications are delivered on commit, so listeners never see
+// uncommitted changes.
+const ChangesChannel = "order_changed"
+
+// ParseChange parses a ChangesChannel payload.
+func ParseChange(payload string) (uuid.UUID, int64, error) {
+	id, rev, ok := strings.Cut(payload, ":")
+	if !ok {
+		return uuid.Nil, 0, errors.Newf("malformed change notification %q", payload)
+	}
+
+	orderID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, 0, errors.Wrapf(err, "malformed change notification %q", payload)
+	}
+
+	revision, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil {
+		return uuid.Nil, 0, errors.Wrapf(err, "malformed change notification %q", payload)
+	}
+
+	return orderID, revision, nil
+}
+
+// Pool is the part of *pgxpool.Pool the repository depends on.
+type Pool interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type Repository struct {
-	pool    *pgxpool.Pool
+	pool    Pool
 	builder sq.StatementBuilderType
 }
 
-func New(pool *pgxpool.Pool) *Repository {
+func New(pool Pool) *Repository {
 	return &Repository{
 		pool:    pool,
 		builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
 	}
 }
 
-func (r *Repository) CreateOrder(ctx context.Context, order model.Order) (model.Order, error) {
+func (r *Repository) CreateOrder(ctx context.Context, order model.Order) (_ model.Order, err error) {
+	ctx, end := startQuery(ctx, "CreateOrder")
+	defer func() { end(err) }()
+
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
 		return model.Order{}, errors.WithStack(err)
@@ -37,27 +78,32 @@ func (r *Repository) CreateOrder(ctx context.Context, order model.Order) (model.
 	if err != nil {
 		return model.Order{}, err
 	}
+	order.Customer = customer
 
 	address, err := r.createAddress(ctx, tx, order.Address)
 	if err != nil {
 		return model.Order{}, err
 	}
+	order.Address = address
 
-	newOrder, err := r.createOrder(ctx, tx, order)
+	newOrder, inserted, err := r.createOrder(ctx, tx, order)
 	if err != nil {
 		return model.Order{}, err
 	}
-	newOrder.Address = address
 
+	// The caller's items must not see the IDs filled in below.
+	order.Items = slices.Clone(order.Items)
+
+	order.Payment.OrderID = newOrder.ID
 	payment, err := r.createPayment(ctx, tx, order.Payment)
 	if err != nil {
 		return model.Order{}, err
 	}
-	newOrder.Payment = payment
 
 	items := make([]model.Item, len(order.Items))
-	for i, orderItem := range order.Items {
-		items[i] = orderItem.Item
+	for i := range order.Items {
+		order.Items[i].OrderID = newOrder.ID
+		items[i] = order.Items[i].Item
 	}
 
 	newItems, err := r.createItems(ctx, tx, items)
@@ -70,15 +116,16 @@ func (r *Repository) CreateOrder(ctx context.Context, order model.Order) (model.
 		return model.Order{}, err
 	}
 
-	err = tx.Commit(ctx)
+	newOrderItems, changes, err := r.createOrderItems(ctx, tx, order.Items)
 	if err != nil {
-		return model.Order{}, errors.WithStack(err)
+		return model.Order{}, err
 	}
 
-	newOrderItems, err := r.createOrderItems(ctx, order.Items)
+	err = r.updateRefund(ctx, tx, newOrder.ID, changes)
 	if err != nil {
 		return model.Order{}, err
 	}
+
 	for i := range newOrderItems {
 		for _, newItem := range newItems {
 			if newOrderItems[i].Item.ID == newItem.ID {
@@ -86,23 +133,93 @@ func (r *Repository) CreateOrder(ctx context.Context, order model.Order) (model.
 				break
 			}
 		}
-	}
 
-	for i := range newOrderItems {
 		for _, size := range sizes {
-			if newOrderItems[i].Item.ID == size.ItemID {
+			if newOrderItems[i].ChrtID == size.ID {
 				newOrderItems[i].Size = size.Size
 				break
 			}
 		}
 	}
+
 	newOrder.Customer = customer
+	newOrder.Address = address
+	newOrder.Payment = payment
 	newOrder.Items = newOrderItems
 
+	// A redelivered order only reports the item statuses it changed.
+	events, err := statusChangedEvents(changes)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if inserted {
+		event, err := orderCreatedEvent(newOrder)
+		if err != nil {
+			return model.Order{}, err
+		}
+		events = append([]model.OutboxEvent{event}, events...)
+	}
+
+	err = r.insertOutbox(ctx, tx, events)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	newOrder.Revision, err = r.notifyChanged(ctx, tx, newOrder.ID)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return model.Order{}, errors.WithStack(err)
+	}
+
 	return newOrder, nil
 }
 
+// startQuery opens a span for a repository method. The returned function
+// ends the span and records the method latency.
+func startQuery(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "Repository."+method)
+
+	return ctx, func(err error) {
+		status := "ok"
+		if err != nil {
+			status = "error"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		metrics.QueryDuration.WithLabelValues(method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// notifyChanged bumps the order revision and announces it on
+// ChangesChannel. The row lock taken by the update orders the revisions of
+// concurrent transactions the way they commit.
+func (r *Repository) notifyChanged(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) (int64, error) {
+	var revision int64
+	err := tx.QueryRow(ctx, `update "order" set revision = revision + 1 where id = $1 returning revision`,
+		orderID).Scan(&revision)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	_, err = tx.Exec(ctx, `select pg_notify($1, $2)`, ChangesChannel, orderID.String()+":"+strconv.FormatInt(revision, 10))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return revision, nil
+}
+
 func (r *Repository) createCustomer(ctx context.Context, tx pgx.Tx, customer model.Customer) (model.Customer, error) {
+	ctx, span := tracer.Start(ctx, "Repository.createCustomer")
+	defer span.End()
+
 	query := `
         insert into customer (id, name, email, phone)
         values ($1, $2, $3, $4)
@@ -143,6 +260,9 @@ type customerRow struct {
 }
 
 func (r *Repository) createAddress(ctx context.Context, tx pgx.Tx, address model.Address) (model.Address, error) {
+	ctx, span := tracer.Start(ctx, "Repository.createAddress")
+	defer span.End()
+
 	query := `
         with a as (
             insert into address (customer_id, zip, city, address, region)
@@ -196,7 +316,11 @@ type addressRow struct {
 	Region     string    `db:"region"`
 }
 
-func (r *Repository) createOrder(ctx context.Context, tx pgx.Tx, order model.Order) (model.Order, error) {
+// createOrder upserts the order row and reports whether it was inserted.
+func (r *Repository) createOrder(ctx context.Context, tx pgx.Tx, order model.Order) (model.Order, bool, error) {
+	ctx, span := tracer.Start(ctx, "Repository.createOrder")
+	defer span.End()
+
 	query := `
         insert into "order" (id, customer_id, address_id, track_number, entry,
                             locale, internal_signature, delivery_service, sm_id, created)
@@ -207,7 +331,8 @@ func (r *Repository) createOrder(ctx context.Context, tx pgx.Tx, order model.Ord
             delivery_service = coalesce(nullif(excluded.delivery_service, ''), "order".delivery_service),
             internal_signature = coalesce(nullif(excluded.internal_signature, ''), "order".internal_signature),
             sm_id = excluded.sm_id
-        returning id, customer_id, track_number, entry, locale, internal_signature, delivery_service, sm_id, created
+        returning id, customer_id, track_number, entry, locale, internal_signature, delivery_service, sm_id, created,
+            (xmax = 0) as inserted
     `
 
 	rows, err := tx.Query(ctx, query,
@@ -223,18 +348,21 @@ func (r *Repository) createOrder(ctx context.Context, tx pgx.Tx, order model.Ord
 		order.Created,
 	)
 	if err != nil {
-		return model.Order{}, errors.WithStack(err)
+		return model.Order{}, false, errors.WithStack(err)
 	}
 
 	row, err := pgx.CollectExactlyOneRow[orderRow](rows, pgx.RowToStructByNameLax[orderRow])
 	if err != nil {
-		return model.Order{}, errors.WithStack(err)
+		return model.Order{}, false, errors.WithStack(err)
 	}
 
-	return r.orderModel(row), nil
+	return r.orderModel(row), row.Inserted, nil
 }
 
 func (r *Repository) createPayment(ctx context.Context, tx pgx.Tx, payment model.Payment) (model.Payment, error) {
+	ctx, span := tracer.Start(ctx, "Repository.createPayment")
+	defer span.End()
+
 	query := `
         insert into payment (order_id, transaction_id, request_id, currency, provider,
                             amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
@@ -248,7 +376,7 @@ func (r *Repository) createPayment(ctx context.Context, tx pgx.Tx, payment model
             payment_dt = excluded.payment_dt,
             bank = excluded.bank
         returning id, order_id, transaction_id, request_id, currency, provider, amount, payment_dt, bank, delivery_cost,
-            goods_total, custom_fee
+            goods_total, custom_fee, refund
     `
 
 	rows, err := tx.Query(ctx, query,
@@ -278,7 +406,8 @@ func (r *Repository) createPayment(ctx context.Context, tx pgx.Tx, payment model
 
 func paymentModel(row paymentRow) model.Payment {
 	return model.Payment{
-		OrderID:       row.ID,
+		ID:            row.ID,
+		OrderID:       row.OrderID,
 		TransactionID: row.TransactionID,
 		RequestID:     row.RequestID,
 		Currency:      row.Currency,
@@ -286,8 +415,10 @@ func paymentModel(row paymentRow) model.Payment {
 		Amount:        row.Amount,
 		Timestamp:     row.PaymentDt,
 		Bank:          row.Bank,
+		DeliveryCost:  row.DeliveryCost,
 		GoodsTotal:    row.GoodsTotal,
 		CustomFee:     row.CustomFee,
+		Refund:        row.Refund,
 	}
 }
 
@@ -304,25 +435,67 @@ type paymentRow struct {
 	DeliveryCost  int64     `db:"delivery_cost"`
 	GoodsTotal    int64     `db:"goods_total"`
 	CustomFee     int64     `db:"custom_fee"`
+	Refund        int64     `db:"refund"`
 }
 
-func (r *Repository) createOrderItems(ctx context.Context, orderItems []model.OrderItem) ([]model.OrderItem, error) {
+// createOrderItems upserts the order items and returns them along with the
+// status changes recorded in the history.
+func (r *Repository) createOrderItems(ctx context.Context, tx pgx.Tx, orderItems []model.OrderItem) (
+	[]model.OrderItem, []model.StatusChange, error) {
+	ctx, span := tracer.Start(ctx, "Repository.createOrderItems")
+	defer span.End()
+
 	b := &pgx.Batch{}
 
+	// Every status change, including the initial one, is appended to the
+	// history in the same statement as the upsert. The stored status is
+	// locked and replaced only if it is unset or the state machine allows
+	// moving from it ($13), so a change committed concurrently is never
+	// overwritten by an invalid transition.
 	query := `
-        insert into order_item (order_id, nm_id, chrt_id, rid, price, sale, quantity,
-                               total_price, status)
-        values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-        on conflict (rid) 
-        do update set status = case 
-									when excluded.status in ('pending', 'processing', 'assembling', 'in_transit', 
-															 'delivered', 'cancelled', 'returned')
-										then excluded.status 
-									else order_item.status 
-        end
-        returning rid, order_id, nm_id, chrt_id, price, sale, quantity, total_price, status, created
+        with previous as (
+            select status from order_item where rid = $4 for update
+        ),
+        upserted as (
+            insert into order_item (order_id, nm_id, chrt_id, rid, price, sale, quantity,
+                                   total_price, status)
+            values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+            on conflict (rid) 
+            do update set status = case 
+                                        when coalesce(order_item.status::text = any($13), true) then excluded.status 
+                                        else order_item.status 
+            end,
+                          version = order_item.version + case
+                                        when coalesce(order_item.status::text = any($13), true)
+                                            and excluded.status is distinct from order_item.status
+                                            then 1
+                                        else 0
+            end,
+                          status_changed_at = case
+                                        when coalesce(order_item.status::text = any($13), true)
+                                            and excluded.status is distinct from order_item.status
+                                            then now()
+                                        else order_item.status_changed_at
+            end
+            returning rid, order_id, nm_id, chrt_id, price, sale, quantity, total_price, status, version,
+                status_changed_at, status_event_at, created
+        ),
+        history as (
+            insert into order_item_status_history (rid, order_id, previous_status, status,
+                                                   source_topic, source_partition, source_offset)
+            select u.rid, u.order_id, p.status, u.status, $10, $11, $12
+            from upserted u
+            left join previous p on true
+            where u.status is not null and u.status is distinct from p.status
+            returning previous_status, changed_at
+        )
+        select u.rid, u.order_id, u.nm_id, u.chrt_id, u.price, u.sale, u.quantity, u.total_price, u.status,
+            u.version, u.status_changed_at, u.status_event_at, u.created, h.previous_status, h.changed_at as status_recorded_at
+        from upserted u
+        left join history h on true
     `
 
+	topic, partition, offset := sourceArgs(ctx)
 	for _, orderItem := range orderItems {
 		b.Queue(query,
 			orderItem.OrderID,
@@ -334,29 +507,600 @@ func (r *Repository) createOrderItems(ctx context.Context, orderItems []model.Or
 			orderItem.Quantity,
 			orderItem.TotalPrice,
 			string(orderItem.Status),
+			topic,
+			partition,
+			offset,
+			allowedFrom(orderItem.Status),
 		)
 	}
-	br := r.pool.SendBatch(ctx, b)
+	br := tx.SendBatch(ctx, b)
 	defer func() { _ = br.Close() }()
 
 	newOrderItems := make([]model.OrderItem, 0, len(orderItems))
+	var changes []model.StatusChange
 	for i := 0; i < b.Len(); i++ {
 		rows, err := br.Query()
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
 
 		row, err := pgx.CollectExactlyOneRow[orderItemRow](rows, pgx.RowToStructByNameLax[orderItemRow])
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
 		newOrderItems = append(newOrderItems, r.orderItemModel(row))
+
+		if row.StatusRecorded != nil {
+			change := model.StatusChange{
+				ItemID:  row.ID,
+				OrderID: row.OrderID,
+				To:      model.ItemStatus(row.Status),
+				Changed: *row.StatusRecorded,
+			}
+			if row.PreviousStatus != nil {
+				change.From = model.ItemStatus(*row.PreviousStatus)
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	return newOrderItems, changes, nil
+}
+
+// allowedFrom returns the stored statuses an item may move to status from.
+func allowedFrom(status model.ItemStatus) []string {
+	from := status.AllowedFrom()
+	statuses := make([]string, len(from))
+	for i, s := range from {
+		statuses[i] = string(s)
+	}
+
+	return statuses
+}
+
+// sourceArgs returns the Kafka source attached to ctx as nullable query
+// arguments.
+func sourceArgs(ctx context.Context) (*string, *int32, *int64) {
+	source, ok := model.SourceFromContext(ctx)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	return &source.Topic, &source.Partition, &source.Offset
+}
+
+// StatusHistory returns the status changes of all items of an order, oldest
+// first.
+func (r *Repository) StatusHistory(ctx context.Context, orderID uuid.UUID) (_ []model.StatusChange, err error) {
+	ctx, end := startQuery(ctx, "StatusHistory")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	query := `
+        select h.rid, h.order_id, h.previous_status, h.status,
+               h.source_topic, h.source_partition, h.source_offset, h.reason, h.changed_at
+        from "order" o
+        left join order_item_status_history h on h.order_id = o.id
+        where o.id = $1
+        order by h.changed_at, h.id
+    `
+
+	rows, err := tx.Query(ctx, query, orderID)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	return newOrderItems, nil
+	historyRows, err := pgx.CollectRows[statusChangeRow](rows, pgx.RowToStructByNameLax[statusChangeRow])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(historyRows) == 0 {
+		return nil, model.ErrOrderNotFound
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	history := make([]model.StatusChange, 0, len(historyRows))
+	for _, row := range historyRows {
+		// An order without any history yields a single row of nulls.
+		if row.ItemID == nil {
+			continue
+		}
+		history = append(history, statusChangeModel(row))
+	}
+
+	return history, nil
+}
+
+type statusChangeRow struct {
+	ItemID          *uuid.UUID `db:"rid"`
+	OrderID         *uuid.UUID `db:"order_id"`
+	PreviousStatus  *string    `db:"previous_status"`
+	Status          *string    `db:"status"`
+	SourceTopic     *string    `db:"source_topic"`
+	SourcePartition *int32     `db:"source_partition"`
+	SourceOffset    *int64     `db:"source_offset"`
+	Reason          *string    `db:"reason"`
+	Changed         *time.Time `db:"changed_at"`
+}
+
+func statusChangeModel(row statusChangeRow) model.StatusChange {
+	change := model.StatusChange{
+		ItemID:  *row.ItemID,
+		OrderID: *row.OrderID,
+		To:      model.ItemStatus(*row.Status),
+		Changed: *row.Changed,
+	}
+
+	if row.PreviousStatus != nil {
+		change.From = model.ItemStatus(*row.PreviousStatus)
+	}
+	if row.SourceTopic != nil {
+		change.Source.Topic = *row.SourceTopic
+	}
+	if row.SourcePartition != nil {
+		change.Source.Partition = *row.SourcePartition
+	}
+	if row.SourceOffset != nil {
+		change.Source.Offset = *row.SourceOffset
+	}
+	if row.Reason != nil {
+		change.Reason = *row.Reason
+	}
+
+	return change
+}
+
+// UpdateItemStatus sets the status of an order item if its version is still
+// version, recording the change in the status history.
+func (r *Repository) UpdateItemStatus(ctx context.Context, orderID, rid uuid.UUID, status model.ItemStatus,
+	version int64) (err error) {
+	ctx, end := startQuery(ctx, "UpdateItemStatus")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	err = r.lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	var current int64
+	err = tx.QueryRow(ctx, `select version from order_item where rid = $1 and order_id = $2 for update`,
+		rid, orderID).Scan(&current)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.WithStack(model.ErrItemNotFound)
+		}
+		return errors.WithStack(err)
+	}
+	if current != version {
+		return errors.WithStack(model.ErrVersionConflict)
+	}
+
+	query := `
+        with previous as (
+            select status from order_item where rid = $1
+        ),
+        updated as (
+            update order_item
+            set status = $2, version = version + 1, status_changed_at = now()
+            where rid = $1
+            returning rid, order_id, status
+        )
+        insert into order_item_status_history (rid, order_id, previous_status, status)
+        select u.rid, u.order_id, p.status, u.status
+        from updated u
+        left join previous p on true
+        where u.status is distinct from p.status
+        returning rid, order_id, previous_status, status, changed_at
+    `
+
+	changes, err := r.recordStatusChanges(ctx, tx, query, rid, string(status))
+	if err != nil {
+		return err
+	}
+
+	err = r.updateRefund(ctx, tx, orderID, changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.notifyChanged(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// ChangeItems applies change in a single transaction: the items move to the
+// new status if none of them has changed since change was decided on, and
+// the payment totals are recalculated if any of them is refunded.
+func (r *Repository) ChangeItems(ctx context.Context, change model.ItemsChange) (err error) {
+	ctx, end := startQuery(ctx, "ChangeItems")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	err = r.lockOrder(ctx, tx, change.OrderID)
+	if err != nil {
+		return err
+	}
+
+	rids := make([]uuid.UUID, len(change.Items))
+	for i, item := range change.Items {
+		rids[i] = item.ID
+	}
+
+	rows, err := tx.Query(ctx, `select rid, version from order_item where order_id = $1 and rid = any($2) for update`,
+		change.OrderID, rids)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	versionRows, err := pgx.CollectRows[itemVersionRow](rows, pgx.RowToStructByNameLax[itemVersionRow])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	versions := make(map[uuid.UUID]int64, len(versionRows))
+	for _, row := range versionRows {
+		versions[row.ID] = row.Version
+	}
+	for _, item := range change.Items {
+		current, ok := versions[item.ID]
+		if !ok {
+			return errors.WithDetailf(model.ErrItemNotFound, "rid %s", item.ID)
+		}
+		if current != item.Version {
+			return errors.WithStack(model.ErrVersionConflict)
+		}
+	}
+
+	query := `
+        with previous as (
+            select rid, status from order_item where rid = any($1)
+        ),
+        updated as (
+            update order_item
+            set status = $2, version = version + 1, status_changed_at = now(),
+                status_event_at = coalesce($7, status_event_at)
+            where rid = any($1)
+            returning rid, order_id, status, status_changed_at
+        )
+        insert into order_item_status_history (rid, order_id, previous_status, status, reason,
+                                               source_topic, source_partition, source_offset, changed_at)
+        select u.rid, u.order_id, p.status, u.status, $3, $4, $5, $6, u.status_changed_at
+        from updated u
+        join previous p on p.rid = u.rid
+        where u.status is distinct from p.status
+        returning rid, order_id, previous_status, status, reason, source_topic, source_partition, source_offset,
+            changed_at
+    `
+
+	var reason *string
+	if change.Reason != "" {
+		reason = &change.Reason
+	}
+	var eventTime *time.Time
+	if !change.EventTime.IsZero() {
+		eventTime = &change.EventTime
+	}
+	topic, partition, offset := sourceArgs(ctx)
+
+	changes, err := r.recordStatusChanges(ctx, tx, query, rids, string(change.Status), reason, topic, partition,
+		offset, eventTime)
+	if err != nil {
+		return err
+	}
+
+	err = r.updateRefund(ctx, tx, change.OrderID, changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.notifyChanged(ctx, tx, change.OrderID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// recordStatusChanges runs a history insert returning the recorded changes
+// and writes an outbox event for each of them.
+func (r *Repository) recordStatusChanges(ctx context.Context, tx pgx.Tx, query string,
+	args ...any) ([]model.StatusChange, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	historyRows, err := pgx.CollectRows[statusChangeRow](rows, pgx.RowToStructByNameLax[statusChangeRow])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	changes := make([]model.StatusChange, 0, len(historyRows))
+	for _, row := range historyRows {
+		changes = append(changes, statusChangeModel(row))
+	}
+
+	events, err := statusChangedEvents(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.insertOutbox(ctx, tx, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// lockOrder locks the order row for the rest of tx. Every transaction
+// changing item statuses takes this lock before touching the items, so that
+// they are serialized per order and take their locks in the same order.
+func (r *Repository) lockOrder(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `select 1 from "order" where id = $1 for no key update`, orderID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// updateRefund recalculates the payment of the order if changes refunded an
+// item. The totals are computed from the items as they are now rather than
+// from the caller's copy of the order, which a concurrent change may have
+// outdated: goods_total covers the items still paid for and refund the
+// cancelled and returned ones, delivery included once every item is
+// cancelled.
+func (r *Repository) updateRefund(ctx context.Context, tx pgx.Tx, orderID uuid.UUID,
+	changes []model.StatusChange) error {
+	if !slices.ContainsFunc(changes, func(change model.StatusChange) bool { return change.To.Refunded() }) {
+		return nil
+	}
+
+	query := `
+        update payment p
+        set goods_total = t.goods_total,
+            refund = t.refund + case when t.cancelled then p.delivery_cost else 0 end
+        from (
+            select coalesce(sum(total_price) filter (where not coalesce(status::text = any($2), false)), 0)
+                       as goods_total,
+                   coalesce(sum(total_price) filter (where status::text = any($2)), 0) as refund,
+                   coalesce(bool_and(status::text = $3), false) as cancelled
+            from order_item
+            where order_id = $1
+        ) t
+        where p.order_id = $1
+    `
+
+	_, err := tx.Exec(ctx, query, orderID, refundedStatuses, string(model.Cancelled))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// refundedStatuses are the item statuses that are no longer paid for.
+var refundedStatuses = []string{string(model.Cancelled), string(model.Returned)}
+
+type itemVersionRow struct {
+	ID      uuid.UUID `db:"rid"`
+	Version int64     `db:"version"`
+}
+
+// ReserveIdempotencyKey claims key for a request with requestHash before it
+// is processed, so that concurrent duplicates are not processed twice. If the
+// key is taken, the response stored under it is returned instead, pending
+// while the other request is still running. A pending reservation older than
+// lease, left behind by a request that never completed, and a completed one
+// older than ttl are taken over.
+func (r *Repository) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, lease,
+	ttl time.Duration) (_ model.IdempotentResponse, _ bool, err error) {
+	ctx, end := startQuery(ctx, "ReserveIdempotencyKey")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return model.IdempotentResponse{}, false, errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// A conflicting insert waits for the transaction holding the key, so the
+	// condition is checked against the committed row.
+	query := `
+        insert into idempotency_key (key, request_hash)
+        values ($1, $2)
+        on conflict (key) do update
+        set request_hash = excluded.request_hash, status_code = null, response = null, created_at = now()
+        where idempotency_key.created_at < now() - case
+            when idempotency_key.status_code is null then $3
+            else $4
+        end * interval '1 millisecond'
+        returning key
+    `
+
+	var reserved string
+	err = tx.QueryRow(ctx, query, key, requestHash, lease.Milliseconds(), ttl.Milliseconds()).Scan(&reserved)
+	switch {
+	case err == nil:
+		err = tx.Commit(ctx)
+		if err != nil {
+			return model.IdempotentResponse{}, false, errors.WithStack(err)
+		}
+		return model.IdempotentResponse{Key: key, RequestHash: requestHash}, true, nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		return model.IdempotentResponse{}, false, errors.WithStack(err)
+	}
+
+	// The key is taken. This statement gets a fresh snapshot, which includes
+	// the row the insert conflicted with.
+	query = `
+        select key, request_hash, status_code, response, created_at
+        from idempotency_key
+        where key = $1
+    `
+
+	rows, err := tx.Query(ctx, query, key)
+	if err != nil {
+		return model.IdempotentResponse{}, false, errors.WithStack(err)
+	}
+
+	row, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[idempotentResponseRow])
+	if err != nil {
+		return model.IdempotentResponse{}, false, errors.WithStack(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return model.IdempotentResponse{}, false, errors.WithStack(err)
+	}
+
+	return idempotentResponseModel(row), false, nil
+}
+
+// SaveIdempotentResponse completes the reservation of resp's key with the
+// response. A reservation taken over in the meantime is left alone.
+func (r *Repository) SaveIdempotentResponse(ctx context.Context, resp model.IdempotentResponse) (err error) {
+	ctx, end := startQuery(ctx, "SaveIdempotentResponse")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	query := `
+        update idempotency_key
+        set status_code = $3, response = $4
+        where key = $1 and request_hash = $2 and status_code is null
+    `
+
+	_, err = tx.Exec(ctx, query, resp.Key, resp.RequestHash, resp.StatusCode, resp.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey drops a pending reservation, so that a retry of a
+// request that failed can be processed.
+func (r *Repository) ReleaseIdempotencyKey(ctx context.Context, key, requestHash string) (err error) {
+	ctx, end := startQuery(ctx, "ReleaseIdempotencyKey")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	_, err = tx.Exec(ctx, `delete from idempotency_key where key = $1 and request_hash = $2 and status_code is null`,
+		key, requestHash)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyKeys removes keys reserved more than ttl ago and returns
+// how many were removed.
+func (r *Repository) DeleteIdempotencyKeys(ctx context.Context, ttl time.Duration) (_ int64, err error) {
+	ctx, end := startQuery(ctx, "DeleteIdempotencyKeys")
+	defer func() { end(err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `delete from idempotency_key where created_at < now() - $1 * interval '1 millisecond'`,
+		ttl.Milliseconds())
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+type idempotentResponseRow struct {
+	Key         string    `db:"key"`
+	RequestHash string    `db:"request_hash"`
+	StatusCode  *int      `db:"status_code"`
+	Response    []byte    `db:"response"`
+	Created     time.Time `db:"created_at"`
+}
+
+func idempotentResponseModel(row idempotentResponseRow) model.IdempotentResponse {
+	resp := model.IdempotentResponse{
+		Key:         row.Key,
+		RequestHash: row.RequestHash,
+		Body:        row.Response,
+		Created:     row.Created,
+	}
+	if row.StatusCode != nil {
+		resp.StatusCode = *row.StatusCode
+	}
+
+	return resp
 }
 
 func (r *Repository) createItems(ctx context.Context, tx pgx.Tx, items []model.Item) ([]model.Item, error) {
+	ctx, span := tracer.Start(ctx, "Repository.createItems")
+	defer span.End()
+
 	b := &pgx.Batch{}
 
 	query := `
@@ -410,6 +1154,9 @@ func modelItem(row itemRow) model.Item {
 }
 
 func (r *Repository) createSizes(ctx context.Context, tx pgx.Tx, orderItems []model.OrderItem) ([]model.Size, error) {
+	ctx, span := tracer.Start(ctx, "Repository.createSizes")
+	defer span.End()
+
 	b := &pgx.Batch{}
 	query := `
         insert into size (id, nm_id, tech_size, price)
@@ -467,7 +1214,10 @@ func (r *Repository) sizeModel(row sizeRow) model.Size {
 	}
 }
 
-func (r *Repository) Orders(ctx context.Context, opts model.OrderFilter) ([]model.Order, error) {
+func (r *Repository) Orders(ctx context.Context, opts model.OrderFilter) (_ []model.Order, err error) {
+	ctx, end := startQuery(ctx, "Orders")
+	defer func() { end(err) }()
+
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -515,6 +1265,7 @@ func (r *Repository) orders(ctx context.Context, tx pgx.Tx, opts model.OrderFilt
 			"o.delivery_service, " +
 			"o.sm_id, " +
 			"o.created," +
+			"o.revision," +
 			"c.name as customer_name, " +
 			"c.email as customer_email, " +
 			"c.phone as customer_phone," +
@@ -531,7 +1282,8 @@ func (r *Repository) orders(ctx context.Context, tx pgx.Tx, opts model.OrderFilt
 			"p.bank, " +
 			"p.delivery_cost," +
 			"p.goods_total, " +
-			"p.custom_fee",
+			"p.custom_fee, " +
+			"p.refund",
 		).From("\"order\" o").
 		LeftJoin("customer c on o.customer_id = c.id").
 		LeftJoin("address a on o.address_id = a.id").
@@ -541,8 +1293,59 @@ func (r *Repository) orders(ctx context.Context, tx pgx.Tx, opts model.OrderFilt
 		b = b.Where(sq.Eq{"o.id": opts.OrderID})
 	}
 
-	if opts.IsRecent {
-		b = b.OrderBy("created desc")
+	if opts.ItemID != uuid.Nil {
+		b = b.Where("exists (select 1 from order_item oi where oi.order_id = o.id and oi.rid = ?)", opts.ItemID)
+	}
+
+	if opts.CustomerID != uuid.Nil {
+		b = b.Where(sq.Eq{"o.customer_id": opts.CustomerID})
+	}
+
+	if opts.TrackNumber != "" {
+		b = b.Where(sq.Eq{"o.track_number": opts.TrackNumber})
+	}
+
+	if opts.DeliveryService != "" {
+		b = b.Where(sq.Eq{"o.delivery_service": opts.DeliveryService})
+	}
+
+	if opts.Locale != "" {
+		b = b.Where(sq.Eq{"o.locale": opts.Locale})
+	}
+
+	if opts.ItemStatus != "" {
+		b = b.Where("exists (select 1 from order_item oi where oi.order_id = o.id and oi.status = ?)",
+			string(opts.ItemStatus))
+	}
+
+	if len(opts.ItemStatuses) > 0 {
+		statuses := make([]string, len(opts.ItemStatuses))
+		for i, status := range opts.ItemStatuses {
+			statuses[i] = string(status)
+		}
+		b = b.Where("exists (select 1 from order_item oi where oi.order_id = o.id and oi.status = any(?))",
+			statuses)
+	}
+
+	if !opts.TouchedFrom.IsZero() {
+		b = b.Where("(o.created >= ? or exists (select 1 from order_item_status_history h "+
+			"where h.order_id = o.id and h.changed_at >= ?))", opts.TouchedFrom, opts.TouchedFrom)
+	}
+
+	if !opts.CreatedFrom.IsZero() {
+		b = b.Where(sq.GtOrEq{"o.created": opts.CreatedFrom})
+	}
+
+	if !opts.CreatedTo.IsZero() {
+		b = b.Where(sq.Lt{"o.created": opts.CreatedTo})
+	}
+
+	if opts.After != nil {
+		b = b.Where("(o.created, o.id) < (?, ?)", opts.After.Created, opts.After.ID)
+	}
+
+	if opts.IsRecent || opts.After != nil {
+		b = b.OrderBy("o.created desc", "o.id desc")
 	}
 
 	if opts.Limit > 0 {
@@ -586,6 +1389,7 @@ func (r *Repository) orderModel(row orderRow) model.Order {
 		DeliveryService:   row.DeliveryService,
 		SmID:              row.SmID,
 		Created:           row.Created,
+		Revision:          row.Revision,
 		Customer: model.Customer{
 			ID:    row.CustomerID,
 			Name:  row.CustomerName,
@@ -610,6 +1414,7 @@ func (r *Repository) orderModel(row orderRow) model.Order {
 			DeliveryCost:  row.DeliveryCost,
 			GoodsTotal:    row.GoodsTotal,
 			CustomFee:     row.CustomFee,
+			Refund:        row.Refund,
 		},
 	}
 }
@@ -624,6 +1429,7 @@ type orderRow struct {
 	DeliveryService   string    `db:"delivery_service"`
 	SmID              int64     `db:"sm_id"`
 	Created           time.Time `db:"created"`
+	Revision          int64     `db:"revision"`
 	CustomerName      string    `db:"customer_name"`
 	CustomerEmail     string    `db:"customer_email"`
 	CustomerPhone     string    `db:"customer_phone"`
@@ -641,6 +1447,8 @@ type orderRow struct {
 	DeliveryCost      int64     `db:"delivery_cost"`
 	GoodsTotal        int64     `db:"goods_total"`
 	CustomFee         int64     `db:"custom_fee"`
+	Refund            int64     `db:"refund"`
+	Inserted          bool      `db:"inserted"`
 	//ChrtID            string    `db:"chrt_id"`
 	//ItemPrice         int64     `db:"item_price"`
 	//ItemSale          int64     `db:"sale"`
@@ -658,6 +1466,9 @@ func (r *Repository) getOrderItems(ctx context.Context, tx pgx.Tx, id ...uuid.UU
             oi.quantity,
             oi.total_price,
             oi.status,
+            oi.version,
+            oi.status_changed_at,
+            oi.status_event_at,
             s.tech_size as size,
             i.nm_id,
             i.brand,
@@ -689,23 +1500,29 @@ func (r *Repository) getOrderItems(ctx context.Context, tx pgx.Tx, id ...uuid.UU
 }
 
 type orderItemRow struct {
-	ID         uuid.UUID `db:"rid"`
-	OrderID    uuid.UUID `db:"order_id"`
-	NmID       uuid.UUID `db:"nm_id"`
-	ChrtID     int64     `db:"chrt_id"`
-	Price      int64     `db:"price"`
-	Sale       int64     `db:"sale"`
-	Quantity   int64     `db:"quantity"`
-	TotalPrice int64     `db:"total_price"`
-	Status     string    `db:"status"`
-	Size       string    `db:"size"`
-	Brand      string    `db:"brand"`
-	Name       string    `db:"name"`
-	Created    time.Time `db:"created"`
+	ID            uuid.UUID  `db:"rid"`
+	OrderID       uuid.UUID  `db:"order_id"`
+	NmID          uuid.UUID  `db:"nm_id"`
+	ChrtID        int64      `db:"chrt_id"`
+	Price         int64      `db:"price"`
+	Sale          int64      `db:"sale"`
+	Quantity      int64      `db:"quantity"`
+	TotalPrice    int64      `db:"total_price"`
+	Status        string     `db:"status"`
+	Version       int64      `db:"version"`
+	StatusChanged time.Time  `db:"status_changed_at"`
+	StatusEvent   *time.Time `db:"status_event_at"`
+	// Set by createOrderItems when the upsert recorded a status change.
+	PreviousStatus *string    `db:"previous_status"`
+	StatusRecorded *time.Time `db:"status_recorded_at"`
+	Size           string     `db:"size"`
+	Brand          string     `db:"brand"`
+	Name           string     `db:"name"`
+	Created        time.Time  `db:"created"`
 }
 
 func (r *Repository) orderItemModel(row orderItemRow) model.OrderItem {
-	return model.OrderItem{
+	item := model.OrderItem{
 		ID:      row.ID,
 		ChrtID:  row.ChrtID,
 		OrderID: row.OrderID,
@@ -714,11 +1531,18 @@ func (r *Repository) orderItemModel(row orderItemRow) model.OrderItem {
 			Name:  row.Name,
 			Brand: row.Brand,
 		},
-		Price:      row.Price,
-		Sale:       row.Sale,
-		Size:       row.Size,
-		Quantity:   row.Quantity,
-		TotalPrice: row.TotalPrice,
-		Status:     model.ItemStatus(row.Status),
+		Price:         row.Price,
+		Sale:          row.Sale,
+		Size:          row.Size,
+		Quantity:      row.Quantity,
+		TotalPrice:    row.TotalPrice,
+		Status:        model.ItemStatus(row.Status),
+		Version:       row.Version,
+		StatusChanged: row.StatusChanged,
 	}
+	if row.StatusEvent != nil {
+		item.StatusEvent = *row.StatusEvent
+	}
+
+	return item
 }
@@ -0,0 +1,658 @@
+package repository_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+	"order_service/internal/model"
+	"order_service/internal/repository"
+)
+
+func TestRepository_CreateOrder(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	order := createTestOrder()
+	addressID := uuid.New()
+	paymentID := uuid.New()
+
+	source := model.Source{Topic: "wb-orders", Partition: 2, Offset: 42}
+
+	expectOrderHeader(pool, order, addressID, paymentID, true)
+
+	batch := pool.ExpectBatch()
+	batch.ExpectQuery("insert into order_item").
+		WithArgs(order.ID, order.Items[0].Item.ID, order.Items[0].ChrtID, order.Items[0].ID, order.Items[0].Price,
+			order.Items[0].Sale, order.Items[0].Quantity, order.Items[0].TotalPrice, string(order.Items[0].Status),
+			&source.Topic, &source.Partition, &source.Offset, []string{"pending"}).
+		WillReturnRows(pgxmock.NewRows([]string{"rid", "order_id", "nm_id", "chrt_id", "price", "sale", "quantity",
+			"total_price", "status", "created", "previous_status", "status_recorded_at"}).
+			AddRow(order.Items[0].ID, order.ID, order.Items[0].Item.ID, order.Items[0].ChrtID, order.Items[0].Price,
+				order.Items[0].Sale, order.Items[0].Quantity, order.Items[0].TotalPrice, "pending", order.Created,
+				(*string)(nil), &order.Created))
+	pool.ExpectExec("INSERT INTO outbox").
+		WithArgs(pgxmock.AnyArg(), model.EventOrderCreated, order.ID, pgxmock.AnyArg(),
+			pgxmock.AnyArg(), model.EventItemStatusChanged, order.ID, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	expectNotify(pool, order.ID, 1)
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	ctx := model.ContextWithSource(context.Background(), source)
+	newOrder, err := repository.New(pool).CreateOrder(ctx, order)
+
+	require.NoError(t, err)
+	require.Equal(t, order.ID, newOrder.ID)
+	require.Equal(t, int64(1), newOrder.Revision)
+	require.Equal(t, addressID, newOrder.Address.ID)
+	require.Equal(t, paymentID, newOrder.Payment.ID)
+	require.Equal(t, order.ID, newOrder.Payment.OrderID)
+	require.Len(t, newOrder.Items, 1)
+	require.Equal(t, order.ID, newOrder.Items[0].OrderID)
+	require.Equal(t, "Mascaras", newOrder.Items[0].Item.Name)
+	require.Equal(t, "0", newOrder.Items[0].Size)
+	require.Equal(t, uuid.Nil, order.Items[0].OrderID, "caller's items are left untouched")
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_CreateOrder_Redelivered(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	order := createTestOrder()
+
+	expectOrderHeader(pool, order, uuid.New(), uuid.New(), false)
+
+	// Neither the order nor the item status changed, so no event is written.
+	batch := pool.ExpectBatch()
+	batch.ExpectQuery("insert into order_item").WithArgs(anyArgs(13)...).
+		WillReturnRows(pgxmock.NewRows([]string{"rid", "order_id", "nm_id", "chrt_id", "status", "previous_status",
+			"status_recorded_at"}).
+			AddRow(order.Items[0].ID, order.ID, order.Items[0].Item.ID, order.Items[0].ChrtID, "pending",
+				(*string)(nil), (*time.Time)(nil)))
+	expectNotify(pool, order.ID, 1)
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	_, err = repository.New(pool).CreateOrder(context.Background(), order)
+
+	require.NoError(t, err)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_CreateOrder_Cancelled(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	order := createTestOrder()
+	order.Items[0].Status = model.Cancelled
+	pending := "pending"
+	changed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	expectOrderHeader(pool, order, uuid.New(), uuid.New(), false)
+
+	// The redelivered order cancels its item, so the payment is recalculated.
+	batch := pool.ExpectBatch()
+	batch.ExpectQuery("insert into order_item").WithArgs(anyArgs(13)...).
+		WillReturnRows(pgxmock.NewRows([]string{"rid", "order_id", "nm_id", "chrt_id", "status", "previous_status",
+			"status_recorded_at"}).
+			AddRow(order.Items[0].ID, order.ID, order.Items[0].Item.ID, order.Items[0].ChrtID, "cancelled",
+				&pending, &changed))
+	pool.ExpectExec("update payment").WithArgs(order.ID, []string{"cancelled", "returned"}, "cancelled").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	pool.ExpectExec("INSERT INTO outbox").
+		WithArgs(pgxmock.AnyArg(), model.EventItemStatusChanged, order.ID, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	expectNotify(pool, order.ID, 2)
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	_, err = repository.New(pool).CreateOrder(context.Background(), order)
+
+	require.NoError(t, err)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_CreateOrder_RollbackOnItemFailure(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	order := createTestOrder()
+	testErr := errors.New("insert or update on table \"order_item\" violates foreign key constraint")
+
+	expectOrderHeader(pool, order, uuid.New(), uuid.New(), true)
+
+	batch := pool.ExpectBatch()
+	batch.ExpectQuery("insert into order_item").WithArgs(anyArgs(13)...).WillReturnError(testErr)
+	pool.ExpectRollback()
+
+	newOrder, err := repository.New(pool).CreateOrder(context.Background(), order)
+
+	require.ErrorIs(t, err, testErr)
+	require.Equal(t, model.Order{}, newOrder)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_StatusHistory(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	orderID, rid := uuid.New(), uuid.New()
+	topic, partition, offset := "wb-orders", int32(1), int64(7)
+	pending, assembling := "pending", "assembling"
+	created := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	changed := created.Add(time.Hour)
+
+	pool.ExpectBegin()
+	pool.ExpectQuery("from \"order\" o").WithArgs(orderID).
+		WillReturnRows(pgxmock.NewRows([]string{"rid", "order_id", "previous_status", "status", "source_topic",
+			"source_partition", "source_offset", "changed_at"}).
+			AddRow(&rid, &orderID, nil, &pending, nil, nil, nil, &created).
+			AddRow(&rid, &orderID, &pending, &assembling, &topic, &partition, &offset, &changed))
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	history, err := repository.New(pool).StatusHistory(context.Background(), orderID)
+
+	require.NoError(t, err)
+	require.Equal(t, []model.StatusChange{
+		{ItemID: rid, OrderID: orderID, To: model.Pending, Changed: created},
+		{
+			ItemID:  rid,
+			OrderID: orderID,
+			From:    model.Pending,
+			To:      model.Assembling,
+			Source:  model.Source{Topic: topic, Partition: partition, Offset: offset},
+			Changed: changed,
+		},
+	}, history)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_StatusHistory_OrderNotFound(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	pool.ExpectBegin()
+	pool.ExpectQuery("from \"order\" o").WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"rid", "order_id", "previous_status", "status", "source_topic",
+			"source_partition", "source_offset", "changed_at"}))
+	pool.ExpectRollback()
+
+	_, err = repository.New(pool).StatusHistory(context.Background(), uuid.New())
+
+	require.ErrorIs(t, err, model.ErrOrderNotFound)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_UpdateItemStatus(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	orderID, rid := uuid.New(), uuid.New()
+	processing, assembling := "processing", "assembling"
+	changed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	pool.ExpectBegin()
+	expectLockOrder(pool, orderID)
+	pool.ExpectQuery("select version from order_item").WithArgs(rid, orderID).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(int64(3)))
+	pool.ExpectQuery("update order_item").WithArgs(rid, "assembling").
+		WillReturnRows(historyRows().AddRow(&rid, &orderID, &processing, &assembling, &changed))
+	pool.ExpectExec("INSERT INTO outbox").
+		WithArgs(pgxmock.AnyArg(), model.EventItemStatusChanged, orderID, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	expectNotify(pool, orderID, 2)
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	err = repository.New(pool).UpdateItemStatus(context.Background(), orderID, rid, model.Assembling, 3)
+
+	require.NoError(t, err)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_UpdateItemStatus_Rejected(t *testing.T) {
+	tests := map[string]struct {
+		rows     *pgxmock.Rows
+		expected error
+	}{
+		"version conflict": {
+			rows:     pgxmock.NewRows([]string{"version"}).AddRow(int64(4)),
+			expected: model.ErrVersionConflict,
+		},
+		"item not found": {
+			rows:     pgxmock.NewRows([]string{"version"}),
+			expected: model.ErrItemNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pool, err := pgxmock.NewPool()
+			require.NoError(t, err)
+			defer pool.Close()
+
+			orderID, rid := uuid.New(), uuid.New()
+
+			pool.ExpectBegin()
+			expectLockOrder(pool, orderID)
+			pool.ExpectQuery("select version from order_item").WithArgs(rid, orderID).WillReturnRows(tt.rows)
+			pool.ExpectRollback()
+
+			err = repository.New(pool).UpdateItemStatus(context.Background(), orderID, rid, model.Assembling, 3)
+
+			require.ErrorIs(t, err, tt.expected)
+			require.NoError(t, pool.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_ChangeItems(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	orderID, first, second := uuid.New(), uuid.New(), uuid.New()
+	rids := []uuid.UUID{first, second}
+	delivered, returned := "delivered", "returned"
+	changed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	pool.ExpectBegin()
+	expectLockOrder(pool, orderID)
+	pool.ExpectQuery("select rid, version from order_item").WithArgs(orderID, rids).
+		WillReturnRows(pgxmock.NewRows([]string{"rid", "version"}).AddRow(first, int64(2)).AddRow(second, int64(1)))
+	pool.ExpectQuery("update order_item").
+		WithArgs(rids, "returned", pgxmock.AnyArg(), (*string)(nil), (*int32)(nil), (*int64)(nil), (*time.Time)(nil)).
+		WillReturnRows(historyRows().
+			AddRow(&first, &orderID, &delivered, &returned, &changed).
+			AddRow(&second, &orderID, &delivered, &returned, &changed))
+	pool.ExpectExec("INSERT INTO outbox").
+		WithArgs(pgxmock.AnyArg(), model.EventItemStatusChanged, orderID, pgxmock.AnyArg(),
+			pgxmock.AnyArg(), model.EventItemStatusChanged, orderID, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	pool.ExpectExec("update payment").WithArgs(orderID, []string{"cancelled", "returned"}, "cancelled").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	expectNotify(pool, orderID, 2)
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	err = repository.New(pool).ChangeItems(context.Background(), model.ItemsChange{
+		OrderID: orderID,
+		Items:   []model.OrderItem{{ID: first, Version: 2}, {ID: second, Version: 1}},
+		Status:  model.Returned,
+		Reason:  "wrong size",
+	})
+
+	require.NoError(t, err)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_ChangeItems_NoRefund(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	orderID, rid := uuid.New(), uuid.New()
+	assembling, inTransit := "assembling", "in_transit"
+	changed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	pool.ExpectBegin()
+	expectLockOrder(pool, orderID)
+	pool.ExpectQuery("select rid, version from order_item").WithArgs(orderID, []uuid.UUID{rid}).
+		WillReturnRows(pgxmock.NewRows([]string{"rid", "version"}).AddRow(rid, int64(1)))
+	pool.ExpectQuery("update order_item").
+		WithArgs([]uuid.UUID{rid}, "in_transit", (*string)(nil), (*string)(nil), (*int32)(nil), (*int64)(nil),
+			&changed).
+		WillReturnRows(historyRows().AddRow(&rid, &orderID, &assembling, &inTransit, &changed))
+	pool.ExpectExec("INSERT INTO outbox").
+		WithArgs(pgxmock.AnyArg(), model.EventItemStatusChanged, orderID, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	expectNotify(pool, orderID, 2)
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	err = repository.New(pool).ChangeItems(context.Background(), model.ItemsChange{
+		OrderID:   orderID,
+		Items:     []model.OrderItem{{ID: rid, Version: 1}},
+		Status:    model.InTransit,
+		EventTime: changed,
+	})
+
+	require.NoError(t, err)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_ChangeItems_Rejected(t *testing.T) {
+	rid := uuid.New()
+
+	tests := map[string]struct {
+		rows     *pgxmock.Rows
+		expected error
+	}{
+		"version conflict": {
+			rows:     pgxmock.NewRows([]string{"rid", "version"}).AddRow(rid, int64(2)),
+			expected: model.ErrVersionConflict,
+		},
+		"item not found": {
+			rows:     pgxmock.NewRows([]string{"rid", "version"}),
+			expected: model.ErrItemNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pool, err := pgxmock.NewPool()
+			require.NoError(t, err)
+			defer pool.Close()
+
+			orderID := uuid.New()
+
+			pool.ExpectBegin()
+			expectLockOrder(pool, orderID)
+			pool.ExpectQuery("select rid, version from order_item").WithArgs(orderID, []uuid.UUID{rid}).
+				WillReturnRows(tt.rows)
+			pool.ExpectRollback()
+
+			err = repository.New(pool).ChangeItems(context.Background(), model.ItemsChange{
+				OrderID: orderID,
+				Items:   []model.OrderItem{{ID: rid, Version: 1}},
+				Status:  model.Cancelled,
+				Reason:  "changed my mind",
+			})
+
+			require.ErrorIs(t, err, tt.expected)
+			require.NoError(t, pool.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_ReserveIdempotencyKey(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	pool.ExpectBegin()
+	pool.ExpectQuery("insert into idempotency_key").
+		WithArgs("key", "hash", time.Minute.Milliseconds(), (24 * time.Hour).Milliseconds()).
+		WillReturnRows(pgxmock.NewRows([]string{"key"}).AddRow("key"))
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	stored, reserved, err := repository.New(pool).ReserveIdempotencyKey(context.Background(), "key", "hash",
+		time.Minute, 24*time.Hour)
+
+	require.NoError(t, err)
+	require.True(t, reserved)
+	require.True(t, stored.Pending())
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_ReserveIdempotencyKey_Taken(t *testing.T) {
+	tests := []struct {
+		name   string
+		status *int
+		body   []byte
+	}{
+		{name: "completed", status: func() *int { s := 201; return &s }(), body: []byte(`{"id":1}`)},
+		{name: "pending"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := pgxmock.NewPool()
+			require.NoError(t, err)
+			defer pool.Close()
+
+			created := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+
+			pool.ExpectBegin()
+			pool.ExpectQuery("insert into idempotency_key").
+				WithArgs("key", "hash", time.Minute.Milliseconds(), (24 * time.Hour).Milliseconds()).
+				WillReturnRows(pgxmock.NewRows([]string{"key"}))
+			pool.ExpectQuery("from idempotency_key").WithArgs("key").
+				WillReturnRows(pgxmock.NewRows([]string{"key", "request_hash", "status_code", "response", "created_at"}).
+					AddRow("key", "other", tt.status, tt.body, created))
+			pool.ExpectCommit()
+			pool.ExpectRollback()
+
+			stored, reserved, err := repository.New(pool).ReserveIdempotencyKey(context.Background(), "key", "hash",
+				time.Minute, 24*time.Hour)
+
+			require.NoError(t, err)
+			require.False(t, reserved)
+			require.Equal(t, "other", stored.RequestHash)
+			require.Equal(t, tt.status == nil, stored.Pending())
+			require.Equal(t, tt.body, stored.Body)
+			require.NoError(t, pool.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_SaveIdempotentResponse(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	resp := model.IdempotentResponse{Key: "key", RequestHash: "hash", StatusCode: 201, Body: []byte(`{}`)}
+
+	pool.ExpectBegin()
+	pool.ExpectExec("update idempotency_key").
+		WithArgs(resp.Key, resp.RequestHash, resp.StatusCode, resp.Body).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	err = repository.New(pool).SaveIdempotentResponse(context.Background(), resp)
+
+	require.NoError(t, err)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_ClaimEvents(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	event := model.OutboxEvent{
+		ID:       uuid.New(),
+		Type:     model.EventOrderCreated,
+		OrderID:  uuid.New(),
+		Payload:  []byte(`{"order_id":"1"}`),
+		Attempts: 2,
+		Created:  time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+	}
+
+	pool.ExpectBegin()
+	pool.ExpectExec("pg_advisory_xact_lock").WithArgs(pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	pool.ExpectQuery("update outbox").WithArgs(100, int64(30000)).
+		WillReturnRows(pgxmock.NewRows([]string{"event_id", "event_type", "order_id", "payload", "attempts",
+			"created_at"}).
+			AddRow(event.ID, event.Type, event.OrderID, event.Payload, event.Attempts, event.Created))
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	events, err := repository.New(pool).ClaimEvents(context.Background(), 100, 30*time.Second)
+
+	require.NoError(t, err)
+	require.Equal(t, []model.OutboxEvent{event}, events)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestRepository_ReleaseEvents(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+
+	pool.ExpectBegin()
+	pool.ExpectExec("update outbox").WithArgs(ids, int64(2000), "kafka: broker not available").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+	pool.ExpectCommit()
+	pool.ExpectRollback()
+
+	err = repository.New(pool).ReleaseEvents(context.Background(), ids, 2*time.Second, "kafka: broker not available")
+
+	require.NoError(t, err)
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestParseChange(t *testing.T) {
+	orderID := uuid.New()
+
+	id, revision, err := repository.ParseChange(orderID.String() + ":42")
+	require.NoError(t, err)
+	require.Equal(t, orderID, id)
+	require.Equal(t, int64(42), revision)
+
+	for _, payload := range []string{orderID.String(), "order:1", orderID.String() + ":x"} {
+		_, _, err = repository.ParseChange(payload)
+		require.Error(t, err, payload)
+	}
+}
+
+// expectOrderHeader expects everything CreateOrder writes before order_item rows.
+// inserted tells whether the order row is new rather than a redelivery.
+func expectOrderHeader(pool pgxmock.PgxPoolIface, order model.Order, addressID, paymentID uuid.UUID,
+	inserted bool) {
+	pool.ExpectBegin()
+	pool.ExpectQuery("insert into customer").
+		WithArgs(order.Customer.ID, order.Customer.Name, order.Customer.Email, order.Customer.Phone).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "email", "phone"}).
+			AddRow(order.Customer.ID, order.Customer.Name, order.Customer.Email, order.Customer.Phone))
+	pool.ExpectQuery("insert into address").
+		WithArgs(order.Customer.ID, order.Address.Zip, order.Address.City, order.Address.Address, order.Address.Region).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "zip", "city", "address", "region"}).
+			AddRow(addressID, order.Customer.ID, order.Address.Zip, order.Address.City, order.Address.Address,
+				order.Address.Region))
+	pool.ExpectQuery(`insert into "order"`).
+		WithArgs(order.ID, order.Customer.ID, addressID, order.TrackNumber, order.Entry, order.Locale,
+			order.InternalSignature, order.DeliveryService, order.SmID, order.Created).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "track_number", "entry", "locale",
+			"internal_signature", "delivery_service", "sm_id", "created", "inserted"}).
+			AddRow(order.ID, order.Customer.ID, order.TrackNumber, order.Entry, order.Locale, order.InternalSignature,
+				order.DeliveryService, order.SmID, order.Created, inserted))
+	pool.ExpectQuery("insert into payment").
+		WithArgs(anyArgs(11)...).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "transaction_id", "request_id", "currency",
+			"provider", "amount", "payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee"}).
+			AddRow(paymentID, order.ID, order.Payment.TransactionID, order.Payment.RequestID, order.Payment.Currency,
+				order.Payment.Provider, order.Payment.Amount, order.Payment.Timestamp, order.Payment.Bank,
+				order.Payment.DeliveryCost, order.Payment.GoodsTotal, order.Payment.CustomFee))
+
+	items := pool.ExpectBatch()
+	items.ExpectQuery("insert into item").
+		WithArgs(anyArgs(4)...).
+		WillReturnRows(pgxmock.NewRows([]string{"nm_id", "name", "brand", "price"}).
+			AddRow(order.Items[0].Item.ID, order.Items[0].Item.Name, order.Items[0].Item.Brand,
+				order.Items[0].Item.Price))
+
+	sizes := pool.ExpectBatch()
+	sizes.ExpectQuery("insert into size").
+		WithArgs(anyArgs(4)...).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "nm_id", "tech_size"}).
+			AddRow(order.Items[0].ChrtID, order.Items[0].Item.ID, order.Items[0].Size))
+}
+
+func expectLockOrder(pool pgxmock.PgxPoolIface, orderID uuid.UUID) {
+	pool.ExpectExec("for no key update").WithArgs(orderID).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+}
+
+func expectNotify(pool pgxmock.PgxPoolIface, orderID uuid.UUID, revision int64) {
+	pool.ExpectQuery("update \"order\" set revision").WithArgs(orderID).
+		WillReturnRows(pgxmock.NewRows([]string{"revision"}).AddRow(revision))
+	pool.ExpectExec("pg_notify").WithArgs(repository.ChangesChannel, orderID.String()+":"+strconv.FormatInt(revision, 10)).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+}
+
+// historyRows returns the columns of status history rows returned by a
+// status change.
+func historyRows() *pgxmock.Rows {
+	return pgxmock.NewRows([]string{"rid", "order_id", "previous_status", "status", "changed_at"})
+}
+
+func anyArgs(n int) []interface{} {
+	args := make([]interface{}, n)
+	for i := range args {
+		args[i] = pgxmock.AnyArg()
+	}
+
+	return args
+}
+
+func createTestOrder() model.Order {
+	orderID := uuid.New()
+	customerID := uuid.New()
+
+	return model.Order{
+		ID:              orderID,
+		TrackNumber:     "WBILMTESTTRACK",
+		Entry:           "WBIL",
+		Locale:          "en",
+		DeliveryService: "meest",
+		SmID:            99,
+		Created:         time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+
+		Customer: model.Customer{
+			ID:    customerID,
+			Name:  "Test Testov",
+			Email: "test@example.com",
+			Phone: "+79990000000",
+		},
+
+		Address: model.Address{
+			CustomerID: customerID,
+			Zip:        "2639809",
+			City:       "Kiryat Mozkin",
+			Address:    "Ploshad Mira 15",
+			Region:     "Kraiot",
+		},
+
+		Payment: model.Payment{
+			OrderID:       orderID,
+			TransactionID: uuid.New(),
+			RequestID:     uuid.New(),
+			Currency:      "USD",
+			Provider:      "wbpay",
+			Amount:        1817,
+			Timestamp:     1637907727,
+			Bank:          "alpha",
+			DeliveryCost:  1500,
+			GoodsTotal:    317,
+		},
+
+		Items: []model.OrderItem{
+			{
+				ID: uuid.New(),
+				Item: model.Item{
+					ID:    uuid.New(),
+					Name:  "Mascaras",
+					Brand: "Vivienne Sabo",
+					Price: 453,
+				},
+				ChrtID:     9934930,
+				Price:      453,
+				Sale:       30,
+				Quantity:   1,
+				TotalPrice: 317,
+				Status:     model.Pending,
+				Size:       "0",
+			},
+		},
+	}
+}
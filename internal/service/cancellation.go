@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"order_service/internal/model"
+)
+
+// CancelOrder cancels every item of the order and refunds it, delivery
+// included. Items that have been handed to delivery cannot be cancelled: the
+// order is then left as is and model.ErrInvalidTransition is returned.
+// Cancelling a cancelled order changes nothing.
+func (s *Service) CancelOrder(ctx context.Context, orderID uuid.UUID, reason string) (model.Order, error) {
+	ctx, span := tracer.Start(ctx, "Service.CancelOrder",
+		trace.WithAttributes(attribute.String("order.id", orderID.String())))
+	defer span.End()
+
+	order, err := s.cancelOrder(ctx, orderID, reason)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return model.Order{}, err
+	}
+
+	return order, nil
+}
+
+func (s *Service) cancelOrder(ctx context.Context, orderID uuid.UUID, reason string) (model.Order, error) {
+	if reason == "" {
+		return model.Order{}, errors.WithStack(model.ErrReasonRequired)
+	}
+
+	order, err := s.fetch(ctx, orderID)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	var items []model.OrderItem
+	for _, item := range order.Items {
+		if item.Status == model.Cancelled {
+			continue
+		}
+		if _, err = item.Status.Transition(model.Cancelled); err != nil {
+			return model.Order{}, errors.WithDetailf(err, "rid %s", item.ID)
+		}
+		items = append(items, item)
+	}
+
+	return s.changeItems(ctx, order, model.ItemsChange{Items: items, Status: model.Cancelled, Reason: reason})
+}
+
+// ReturnItems returns delivered items of the order and refunds them. No rids
+// means every item. Items that are not delivered yet cannot be returned:
+// nothing is changed then and model.ErrInvalidTransition is returned. Items
+// returned before are skipped.
+func (s *Service) ReturnItems(ctx context.Context, orderID uuid.UUID, rids []uuid.UUID,
+	reason string) (model.Order, error) {
+	ctx, span := tracer.Start(ctx, "Service.ReturnItems", trace.WithAttributes(
+		attribute.String("order.id", orderID.String()),
+		attribute.Int("items", len(rids)),
+	))
+	defer span.End()
+
+	order, err := s.returnItems(ctx, orderID, rids, reason)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return model.Order{}, err
+	}
+
+	return order, nil
+}
+
+func (s *Service) returnItems(ctx context.Context, orderID uuid.UUID, rids []uuid.UUID,
+	reason string) (model.Order, error) {
+	if reason == "" {
+		return model.Order{}, errors.WithStack(model.ErrReasonRequired)
+	}
+
+	order, err := s.fetch(ctx, orderID)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	if len(rids) == 0 {
+		for _, item := range order.Items {
+			rids = append(rids, item.ID)
+		}
+	}
+
+	var items []model.OrderItem
+	seen := make(map[uuid.UUID]struct{}, len(rids))
+	for _, rid := range rids {
+		i := slices.IndexFunc(order.Items, func(item model.OrderItem) bool { return item.ID == rid })
+		if i < 0 {
+			return model.Order{}, errors.WithDetailf(model.ErrItemNotFound, "rid %s", rid)
+		}
+		if _, ok := seen[rid]; ok {
+			continue
+		}
+		seen[rid] = struct{}{}
+
+		item := order.Items[i]
+		if item.Status == model.Returned {
+			continue
+		}
+		if _, err = item.Status.Transition(model.Returned); err != nil {
+			return model.Order{}, errors.WithDetailf(err, "rid %s", item.ID)
+		}
+		items = append(items, item)
+	}
+
+	return s.changeItems(ctx, order, model.ItemsChange{Items: items, Status: model.Returned, Reason: reason})
+}
+
+// changeItems applies change to items of order and returns the updated
+// order. Cancelled and returned items change the payment, which the
+// repository recalculates along with them. It fails with
+// model.ErrVersionConflict if any of the items changed after order was read.
+func (s *Service) changeItems(ctx context.Context, order model.Order, change model.ItemsChange) (model.Order, error) {
+	if len(change.Items) == 0 {
+		return order, nil
+	}
+	change.OrderID = order.ID
+
+	err := s.repository.ChangeItems(ctx, change)
+	if err != nil {
+		s.refreshOnConflict(ctx, order.ID, err)
+		return model.Order{}, err
+	}
+
+	return s.fetch(ctx, order.ID)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// revisionRetention is how long the latest revision of an order is
+// remembered. It must outlast the slowest load, or a load that read an order
+// before a change may cache it unnoticed after the change was announced.
+const revisionRetention = time.Minute
+
+// revisions remembers the latest known revision of recently loaded or
+// changed orders, so that cached copies older than a change announced by
+// another replica are not served.
+type revisions struct {
+	mu     sync.RWMutex
+	latest map[string]revision
+	pruned time.Time
+}
+
+type revision struct {
+	value int64
+	seen  time.Time
+}
+
+func newRevisions() *revisions {
+	return &revisions{
+		latest: make(map[string]revision),
+		pruned: time.Now(),
+	}
+}
+
+// observe records rev for key and returns the latest revision known before,
+// if any.
+func (r *revisions) observe(key string, rev int64) (int64, bool) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.pruned) >= revisionRetention {
+		for k, known := range r.latest {
+			if now.Sub(known.seen) >= revisionRetention {
+				delete(r.latest, k)
+			}
+		}
+		r.pruned = now
+	}
+
+	known, ok := r.latest[key]
+	if !ok || known.value <= rev {
+		r.latest[key] = revision{value: rev, seen: now}
+	}
+
+	return known.value, ok
+}
+
+// outdated reports whether a later revision than rev is known for key.
+func (r *revisions) outdated(key string, rev int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	known, ok := r.latest[key]
+	return ok && known.value > rev
+}
+
+// seen reports whether any revision is known for key.
+func (r *revisions) seen(key string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.latest[key]
+	return ok
+}
@@ -2,74 +2,507 @@ package service
 
 import (
 	"context"
+	"io/fs"
+	"sync/atomic"
+	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"order_service/internal/metrics"
 	"order_service/internal/model"
 )
 
 type Repository interface {
 	Orders(ctx context.Context, opts model.OrderFilter) ([]model.Order, error)
 	CreateOrder(ctx context.Context, order model.Order) (model.Order, error)
+	StatusHistory(ctx context.Context, orderID uuid.UUID) ([]model.StatusChange, error)
+	ReserveIdempotencyKey(ctx context.Context, key, requestHash string, lease,
+		ttl time.Duration) (model.IdempotentResponse, bool, error)
+	SaveIdempotentResponse(ctx context.Context, resp model.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, key, requestHash string) error
+	DeleteIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error)
+	UpdateItemStatus(ctx context.Context, orderID, rid uuid.UUID, status model.ItemStatus, version int64) error
+	ChangeItems(ctx context.Context, change model.ItemsChange) error
 }
 
 type Cache interface {
-	Set(key string, value interface{})
-	Get(key string) interface{}
+	Set(key string, order model.Order)
+	Get(key string) (model.Order, bool)
+}
+
+// StaleCache is implemented by caches that can serve recently expired
+// entries. Service.Order then returns them immediately and refreshes them in
+// the background.
+type StaleCache interface {
+	GetStale(key string) (order model.Order, ok bool, fresh bool)
+}
+
+// NegativeCache remembers order IDs the repository did not know about.
+type NegativeCache interface {
+	Set(key string, value struct{})
+	Get(key string) (struct{}, bool)
+	Delete(key string)
+}
+
+// Snapshot restores the cache contents saved by a previous run.
+type Snapshot interface {
+	Restore() (int, error)
+}
+
+type Option func(*Service)
+
+// WithNegativeCache caches "not found" answers in c, which should be small
+// and short-lived; it is kept apart from the order cache so that random IDs
+// cannot evict real orders.
+func WithNegativeCache(c NegativeCache) Option {
+	return func(s *Service) {
+		s.notFound = c
+	}
+}
+
+// Default lifetimes of Idempotency-Key reservations and of stored responses.
+const (
+	DefaultIdempotencyLease = time.Minute
+	DefaultIdempotencyTTL   = 24 * time.Hour
+)
+
+// WithIdempotency sets how long an Idempotency-Key reserved by a request that
+// never completed stays blocked, which must exceed the longest request, and
+// how long a stored response is replayed. Zero values keep the defaults.
+func WithIdempotency(lease, ttl time.Duration) Option {
+	return func(s *Service) {
+		if lease > 0 {
+			s.idempotencyLease = lease
+		}
+		if ttl > 0 {
+			s.idempotencyTTL = ttl
+		}
+	}
+}
+
+var tracer = otel.Tracer("order_service/internal/service")
+
+var errWarmUpPending = errors.New("cache warm-up has not completed")
+
+// WithSnapshot makes WarmUpCache restore the cache from s, querying the
+// repository only if there is nothing to restore.
+func WithSnapshot(s Snapshot) Option {
+	return func(svc *Service) {
+		svc.snapshot = s
+	}
 }
 
 type Service struct {
 	repository Repository
 	cache      Cache
+	notFound   NegativeCache
+	snapshot   Snapshot
+	strategies []WarmUpStrategy
+	batchSize  uint64
 	limit      uint64
+	warmedUp   atomic.Bool
+	loads      singleflight.Group
+	revisions  *revisions
+
+	idempotencyLease time.Duration
+	idempotencyTTL   time.Duration
 }
 
-func New(repository Repository, cache Cache, limit uint64) *Service {
-	return &Service{
+func New(repository Repository, cache Cache, limit uint64, opts ...Option) *Service {
+	s := &Service{
 		repository: repository,
 		cache:      cache,
 		limit:      limit,
+		revisions:  newRevisions(),
+
+		idempotencyLease: DefaultIdempotencyLease,
+		idempotencyTTL:   DefaultIdempotencyTTL,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(s.strategies) == 0 {
+		s.strategies = []WarmUpStrategy{RecentOrders{Limit: limit}}
+		s.batchSize = limit
 	}
+
+	return s
 }
 
 func (s *Service) Order(ctx context.Context, orderID uuid.UUID) (model.Order, error) {
-	order := s.cache.Get(orderID.String())
-	if order != nil {
-		return order.(model.Order), nil
+	key := orderID.String()
+
+	if cache, ok := s.cache.(StaleCache); ok {
+		order, ok, fresh := cache.GetStale(key)
+		if ok && !s.revisions.outdated(key, order.Revision) {
+			if !fresh {
+				s.refresh(ctx, orderID)
+			}
+			return order, nil
+		}
+	} else if order, ok := s.cache.Get(key); ok && !s.revisions.outdated(key, order.Revision) {
+		return order, nil
+	}
+
+	if s.notFound != nil {
+		if _, ok := s.notFound.Get(key); ok {
+			metrics.CacheNegativeHits.Inc()
+			return model.Order{}, model.ErrOrderNotFound
+		}
+	}
+
+	return s.load(ctx, orderID)
+}
+
+// load queries the repository once for all concurrent callers asking for
+// the same order. A caller that gives up stops waiting, but the query keeps
+// running for the others.
+func (s *Service) load(ctx context.Context, orderID uuid.UUID) (model.Order, error) {
+	result := s.loads.DoChan(orderID.String(), func() (interface{}, error) {
+		return s.fetch(context.WithoutCancel(ctx), orderID)
+	})
+
+	select {
+	case <-ctx.Done():
+		return model.Order{}, errors.WithStack(ctx.Err())
+	case r := <-result:
+		if r.Err != nil {
+			return model.Order{}, r.Err
+		}
+		return r.Val.(model.Order), nil
 	}
+}
+
+// refresh reloads a stale order in the background, joining a load already in
+// flight for it if there is one.
+func (s *Service) refresh(ctx context.Context, orderID uuid.UUID) {
+	s.loads.DoChan(orderID.String(), func() (interface{}, error) {
+		order, err := s.fetch(context.WithoutCancel(ctx), orderID)
+		if err != nil {
+			log.Warn().Err(err).Str("order_id", orderID.String()).Msg("Failed to refresh stale order")
+		}
+		return order, err
+	})
+}
 
+func (s *Service) fetch(ctx context.Context, orderID uuid.UUID) (model.Order, error) {
 	orders, err := s.repository.Orders(ctx, model.OrderFilter{
 		OrderID: orderID,
 	})
 	if err != nil {
+		if s.notFound != nil && errors.Is(err, model.ErrOrderNotFound) {
+			s.rememberNotFound(orderID.String())
+		}
 		return model.Order{}, err
 	}
 
-	s.cache.Set(orderID.String(), orders[0])
+	s.store(orders[0])
 
 	return orders[0], nil
 }
 
+// rememberNotFound caches a "not found" answer. The order may have been
+// created while the query ran and its creation already cleared the negative
+// cache, so the answer is dropped again if a revision of the order is known
+// by now.
+func (s *Service) rememberNotFound(key string) {
+	s.notFound.Set(key, struct{}{})
+	if s.revisions.seen(key) {
+		s.notFound.Delete(key)
+	}
+}
+
+// store caches order unless a later revision of it has been seen: a load may
+// have read the order just before another change was committed.
+func (s *Service) store(order model.Order) {
+	key := order.ID.String()
+	if latest, ok := s.revisions.observe(key, order.Revision); ok && latest > order.Revision {
+		return
+	}
+
+	s.cache.Set(key, order)
+}
+
+// Changed records a change notification for an order and reports whether
+// in-process copies of the order are outdated and must be evicted. They are
+// not if this replica has already cached the announced revision, as it does
+// after its own writes.
+func (s *Service) Changed(orderID uuid.UUID, revision int64) bool {
+	latest, ok := s.revisions.observe(orderID.String(), revision)
+	return !ok || latest < revision
+}
+
+// History returns the status changes of the order's items, oldest first.
+func (s *Service) History(ctx context.Context, orderID uuid.UUID) ([]model.StatusChange, error) {
+	return s.repository.StatusHistory(ctx, orderID)
+}
+
+// ReserveIdempotencyKey claims an Idempotency-Key for a request before it is
+// processed. If the key is already taken, it reports false and returns the
+// response stored under the key, which is pending while the request holding
+// it is still being processed.
+func (s *Service) ReserveIdempotencyKey(ctx context.Context, key,
+	requestHash string) (model.IdempotentResponse, bool, error) {
+	return s.repository.ReserveIdempotencyKey(ctx, key, requestHash, s.idempotencyLease, s.idempotencyTTL)
+}
+
+// SaveIdempotentResponse stores the response to the request that reserved
+// resp's key.
+func (s *Service) SaveIdempotentResponse(ctx context.Context, resp model.IdempotentResponse) error {
+	return s.repository.SaveIdempotentResponse(ctx, resp)
+}
+
+// ReleaseIdempotencyKey frees a reserved key without storing a response, so
+// that the request can be retried.
+func (s *Service) ReleaseIdempotencyKey(ctx context.Context, key, requestHash string) error {
+	return s.repository.ReleaseIdempotencyKey(ctx, key, requestHash)
+}
+
+// ExpireIdempotencyKeys deletes expired Idempotency-Keys every interval until
+// ctx is done.
+func (s *Service) ExpireIdempotencyKeys(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		deleted, err := s.repository.DeleteIdempotencyKeys(ctx, s.idempotencyTTL)
+		if err != nil {
+			log.Error().Stack().Err(err).Msg("Failed to delete expired idempotency keys")
+			continue
+		}
+		if deleted > 0 {
+			log.Info().Int64("deleted", deleted).Msg("Expired idempotency keys deleted")
+		}
+	}
+}
+
+func (s *Service) Orders(ctx context.Context, filter model.OrderFilter) (model.OrderPage, error) {
+	if filter.Limit == 0 || filter.Limit > s.limit {
+		filter.Limit = s.limit
+	}
+	pageSize := filter.Limit
+
+	// One extra row tells whether there is a next page.
+	filter.Limit++
+	filter.IsRecent = true
+
+	orders, err := s.repository.Orders(ctx, filter)
+	if err != nil {
+		if errors.Is(err, model.ErrOrderNotFound) {
+			return model.OrderPage{Orders: []model.Order{}}, nil
+		}
+		return model.OrderPage{}, err
+	}
+
+	page := model.OrderPage{Orders: orders}
+	if uint64(len(orders)) > pageSize {
+		page.Orders = orders[:pageSize]
+		last := page.Orders[pageSize-1]
+		page.Next = &model.OrderCursor{Created: last.Created, ID: last.ID}
+	}
+
+	return page, nil
+}
+
 func (s *Service) ProcessOrder(ctx context.Context, order model.Order) error {
+	ctx, span := tracer.Start(ctx, "Service.ProcessOrder",
+		trace.WithAttributes(attribute.String("order.id", order.ID.String())))
+	defer span.End()
+
 	newOrder, err := s.repository.CreateOrder(ctx, order)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
+	reportRejected(ctx, order, newOrder)
+
+	s.store(newOrder)
+	if s.notFound != nil {
+		s.notFound.Delete(newOrder.ID.String())
+	}
 
-	s.cache.Set(newOrder.ID.String(), newOrder)
 	return nil
 }
 
-func (s *Service) WarmUpCache(ctx context.Context) error {
-	orders, err := s.repository.Orders(ctx, model.OrderFilter{
-		IsRecent: true,
-		Limit:    s.limit,
-	})
+// UpdateItemStatus moves an order item to status and returns the updated
+// order. A non-zero version must match the item's current version, otherwise
+// model.ErrVersionConflict is returned; the cached order is refreshed in that
+// case too, so the caller can read the current version. Cancelling or
+// returning an item requires a reason, as with CancelOrder and ReturnItems.
+func (s *Service) UpdateItemStatus(ctx context.Context, orderID, rid uuid.UUID, status model.ItemStatus,
+	reason string, version int64) (model.Order, error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdateItemStatus", trace.WithAttributes(
+		attribute.String("order.id", orderID.String()),
+		attribute.String("item.rid", rid.String()),
+		attribute.String("item.status", string(status)),
+	))
+	defer span.End()
+
+	order, err := s.updateItemStatus(ctx, orderID, rid, status, reason, version)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return model.Order{}, err
+	}
+
+	return order, nil
+}
+
+func (s *Service) updateItemStatus(ctx context.Context, orderID, rid uuid.UUID, status model.ItemStatus,
+	reason string, version int64) (model.Order, error) {
+	order, err := s.fetch(ctx, orderID)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	var item *model.OrderItem
+	for i := range order.Items {
+		if order.Items[i].ID == rid {
+			item = &order.Items[i]
+			break
+		}
+	}
+	if item == nil {
+		return model.Order{}, errors.WithStack(model.ErrItemNotFound)
+	}
+
+	if version != 0 && item.Version != version {
+		return model.Order{}, errors.WithStack(model.ErrVersionConflict)
+	}
+
+	if _, err = item.Status.Transition(status); err != nil {
+		return model.Order{}, err
+	}
+	if item.Status == status {
+		return order, nil
+	}
+
+	// Cancelled and returned items change the payment as well.
+	if status.Refunded() {
+		if reason == "" {
+			return model.Order{}, errors.WithStack(model.ErrReasonRequired)
+		}
+		return s.changeItems(ctx, order, model.ItemsChange{
+			Items:  []model.OrderItem{*item},
+			Status: status,
+			Reason: reason,
+		})
+	}
+
+	err = s.repository.UpdateItemStatus(ctx, orderID, rid, status, item.Version)
 	if err != nil {
+		s.refreshOnConflict(ctx, orderID, err)
+		return model.Order{}, err
+	}
+
+	return s.fetch(ctx, orderID)
+}
+
+// refreshOnConflict reloads the cached order if err reports a concurrent
+// change, so that the caller can read the current item versions.
+func (s *Service) refreshOnConflict(ctx context.Context, orderID uuid.UUID, err error) {
+	if !errors.Is(err, model.ErrVersionConflict) {
+		return
+	}
+
+	if _, fetchErr := s.fetch(ctx, orderID); fetchErr != nil {
+		log.Warn().Err(fetchErr).Str("order_id", orderID.String()).Msg("Failed to refresh order")
+	}
+}
+
+// reportRejected reports the item statuses of requested that the repository
+// kept because they were not reachable from the stored ones.
+func reportRejected(ctx context.Context, requested, stored model.Order) {
+	statuses := make(map[uuid.UUID]model.ItemStatus, len(stored.Items))
+	for _, item := range stored.Items {
+		statuses[item.ID] = item.Status
+	}
+
+	for _, item := range requested.Items {
+		current, ok := statuses[item.ID]
+		if !ok || current == item.Status {
+			continue
+		}
+
+		if _, err := current.Transition(item.Status); err != nil {
+			rejectTransition(ctx, requested.ID, item.ID, current, item.Status, err)
+		}
+	}
+}
+
+// rejectTransition reports an item status update that was not applied
+// because the status state machine does not allow it.
+func rejectTransition(ctx context.Context, orderID, rid uuid.UUID, from, to model.ItemStatus, err error) {
+	log.Warn().Err(err).
+		Str("order_id", orderID.String()).
+		Str("rid", rid.String()).
+		Str("from", string(from)).
+		Str("to", string(to)).
+		Msg("Rejected item status transition")
+	metrics.TransitionsRejected.WithLabelValues(string(from), string(to)).Inc()
+	trace.SpanFromContext(ctx).AddEvent("item status transition rejected", trace.WithAttributes(
+		attribute.String("item.rid", rid.String()),
+		attribute.String("item.status.from", string(from)),
+		attribute.String("item.status.to", string(to)),
+	))
+}
+
+func (s *Service) WarmUpCache(ctx context.Context) error {
+	if s.restoreSnapshot() {
+		s.warmedUp.Store(true)
+		return nil
+	}
+
+	if err := s.warmUp(ctx); err != nil {
 		return err
 	}
 
-	for _, order := range orders {
-		s.cache.Set(order.ID.String(), order)
+	s.warmedUp.Store(true)
+	return nil
+}
+
+// restoreSnapshot reports whether the cache was filled from the snapshot. A
+// missing, empty or unreadable snapshot is not an error: the caller falls
+// back to the repository.
+func (s *Service) restoreSnapshot() bool {
+	if s.snapshot == nil {
+		return false
+	}
+
+	restored, err := s.snapshot.Restore()
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Info().Msg("No cache snapshot, warming up from the database")
+		return false
+	case err != nil:
+		log.Warn().Err(err).Msg("Failed to restore cache snapshot, warming up from the database")
+		return false
+	case restored == 0:
+		log.Info().Msg("Cache snapshot has no live orders, warming up from the database")
+		return false
+	}
+
+	log.Info().Int("orders", restored).Msg("Cache restored from snapshot")
+	return true
+}
+
+// CheckWarmUp reports whether the initial cache warm-up has completed.
+func (s *Service) CheckWarmUp(_ context.Context) error {
+	if !s.warmedUp.Load() {
+		return errWarmUpPending
 	}
 
 	return nil
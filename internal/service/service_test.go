@@ -2,12 +2,19 @@ package service_test
 
 import (
 	"context"
+	"io/fs"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"order_service/internal/cache"
+	"order_service/internal/metrics"
 	mockservice "order_service/internal/mocks/service"
 	"order_service/internal/model"
 	"order_service/internal/service"
@@ -25,7 +32,7 @@ func TestService_Order_FromCache(t *testing.T) {
 		ID: id,
 	}
 
-	c.EXPECT().Get(id.String()).Return(expected).Once()
+	c.EXPECT().Get(id.String()).Return(expected, true).Once()
 
 	order, err := s.Order(context.Background(), id)
 
@@ -44,13 +51,13 @@ func TestService_Order_FromRepository(t *testing.T) {
 
 	s := service.New(r, c, 100)
 
-	c.EXPECT().Get(id.String()).Return(nil).Once()
+	c.EXPECT().Get(id.String()).Return(model.Order{}, false).Once()
 
 	expected := model.Order{
 		ID: id,
 	}
 
-	r.EXPECT().Orders(context.Background(), model.OrderFilter{
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{
 		OrderID: id,
 	}).Return([]model.Order{expected}, nil).Once()
 
@@ -71,10 +78,10 @@ func TestService_Order_RepositoryError(t *testing.T) {
 
 	s := service.New(r, c, 100)
 
-	c.EXPECT().Get(id.String()).Return(nil).Once()
+	c.EXPECT().Get(id.String()).Return(model.Order{}, false).Once()
 
 	testErr := model.ErrOrderNotFound
-	r.EXPECT().Orders(ctx, model.OrderFilter{
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{
 		OrderID: id,
 	}).Return(nil, testErr).Once()
 
@@ -96,9 +103,9 @@ func TestService_Order_Empty(t *testing.T) {
 
 	s := service.New(r, c, 100)
 
-	c.EXPECT().Get(id.String()).Return(nil).Once()
+	c.EXPECT().Get(id.String()).Return(model.Order{}, false).Once()
 
-	r.EXPECT().Orders(ctx, model.OrderFilter{
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{
 		OrderID: id,
 	}).Return([]model.Order{}, model.ErrOrderNotFound).Once()
 
@@ -111,6 +118,275 @@ func TestService_Order_Empty(t *testing.T) {
 	require.Equal(t, model.Order{}, order)
 }
 
+func TestService_Order_Coalesced(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	expected := model.Order{ID: id}
+	const callers = 10
+
+	c := mockservice.NewCache(t)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	var misses sync.WaitGroup
+	misses.Add(callers)
+	c.EXPECT().Get(id.String()).Run(func(string) { misses.Done() }).Return(model.Order{}, false).Times(callers)
+
+	release := make(chan struct{})
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+		Run(func(context.Context, model.OrderFilter) { <-release }).
+		Return([]model.Order{expected}, nil).Once()
+	c.EXPECT().Set(id.String(), expected).Return().Once()
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			order, err := s.Order(ctx, id)
+			require.NoError(t, err)
+			require.Equal(t, expected, order)
+		}()
+	}
+
+	misses.Wait()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func TestService_Order_CallerCancelled(t *testing.T) {
+	id := uuid.New()
+
+	c := mockservice.NewCache(t)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	release := make(chan struct{})
+	c.EXPECT().Get(id.String()).Return(model.Order{}, false).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+		Run(func(context.Context, model.OrderFilter) { <-release }).
+		Return([]model.Order{{ID: id}}, nil).Once()
+	set := make(chan struct{})
+	c.EXPECT().Set(id.String(), model.Order{ID: id}).Run(func(string, model.Order) { close(set) }).Return().Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.Order(ctx, id)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// The shared load still completes and fills the cache.
+	close(release)
+	<-set
+}
+
+func TestService_Order_StaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+
+	ttl := 100 * time.Millisecond
+	c := cache.New[string, model.Order](10, ttl, cache.WithStaleGrace(time.Minute))
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stale := model.Order{ID: id, TrackNumber: "STALE"}
+	fresh := model.Order{ID: id, TrackNumber: "FRESH"}
+
+	c.Set(id.String(), stale)
+	time.Sleep(ttl + 10*time.Millisecond)
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+		Return([]model.Order{fresh}, nil).Once()
+
+	order, err := s.Order(ctx, id)
+
+	require.NoError(t, err)
+	require.Equal(t, stale, order)
+
+	require.Eventually(t, func() bool {
+		_, ok := c.Get(id.String())
+		return ok
+	}, ttl, time.Millisecond)
+
+	order, err = s.Order(ctx, id)
+
+	require.NoError(t, err)
+	require.Equal(t, fresh, order)
+}
+
+func TestService_Order_NegativeCache(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+
+	notFound := cache.New[string, struct{}](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, cache.New[string, model.Order](10, time.Minute), 100, service.WithNegativeCache(notFound))
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+		Return(nil, model.ErrOrderNotFound).Once()
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Order(ctx, id)
+		require.ErrorIs(t, err, model.ErrOrderNotFound)
+	}
+
+	order := model.Order{ID: id}
+	r.EXPECT().CreateOrder(mock.Anything, order).Return(order, nil).Once()
+
+	require.NoError(t, s.ProcessOrder(ctx, order))
+	_, ok := notFound.Get(id.String())
+	require.False(t, ok)
+
+	found, err := s.Order(ctx, id)
+
+	require.NoError(t, err)
+	require.Equal(t, order, found)
+}
+
+func TestService_Order_NegativeCacheRace(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	order := model.Order{ID: id, Revision: 1}
+
+	notFound := cache.New[string, struct{}](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, cache.New[string, model.Order](10, time.Minute), 100, service.WithNegativeCache(notFound))
+
+	// The order arrives while the load that misses it is still running.
+	r.EXPECT().CreateOrder(mock.Anything, order).Return(order, nil).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+		Run(func(context.Context, model.OrderFilter) { require.NoError(t, s.ProcessOrder(ctx, order)) }).
+		Return(nil, model.ErrOrderNotFound).Once()
+
+	_, err := s.Order(ctx, id)
+	require.ErrorIs(t, err, model.ErrOrderNotFound)
+
+	_, ok := notFound.Get(id.String())
+	require.False(t, ok)
+
+	found, err := s.Order(ctx, id)
+
+	require.NoError(t, err)
+	require.Equal(t, order, found)
+}
+
+func TestService_Order_OutdatedRevision(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	// A load that read revision 1 before revision 2 was announced does not
+	// replace the copy cached afterwards.
+	require.True(t, s.Changed(id, 2))
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+		Return([]model.Order{{ID: id, Revision: 1}}, nil).Once()
+
+	order, err := s.Order(ctx, id)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(1), order.Revision)
+	_, ok := c.Get(id.String())
+	require.False(t, ok)
+
+	// A cached copy older than an announced revision is reloaded.
+	c.Set(id.String(), model.Order{ID: id, Revision: 1})
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+		Return([]model.Order{{ID: id, Revision: 2}}, nil).Once()
+
+	order, err = s.Order(ctx, id)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2), order.Revision)
+
+	order, err = s.Order(ctx, id)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2), order.Revision)
+}
+
+func TestService_Changed(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+
+	c := mockservice.NewCache(t)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	// Nothing is known about the order, so a cached copy may be outdated.
+	require.True(t, s.Changed(id, 1))
+
+	order := model.Order{ID: id, Revision: 2}
+	r.EXPECT().CreateOrder(mock.Anything, order).Return(order, nil).Once()
+	c.EXPECT().Set(id.String(), order).Return().Once()
+
+	require.NoError(t, s.ProcessOrder(ctx, order))
+
+	// The notification of this replica's own write keeps the cached order.
+	require.False(t, s.Changed(id, 2))
+	require.True(t, s.Changed(id, 3))
+}
+
+func TestService_Orders(t *testing.T) {
+	ctx := context.Background()
+
+	c := mockservice.NewCache(t)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 2)
+
+	testOrders := []model.Order{
+		createTestOrder(),
+		createTestOrder(),
+		createTestOrder(),
+	}
+
+	r.EXPECT().Orders(ctx, model.OrderFilter{
+		Locale:   "en",
+		IsRecent: true,
+		Limit:    3,
+	}).Return(testOrders, nil).Once()
+
+	page, err := s.Orders(ctx, model.OrderFilter{
+		Locale: "en",
+		Limit:  10,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, testOrders[:2], page.Orders)
+	require.Equal(t, &model.OrderCursor{Created: testOrders[1].Created, ID: testOrders[1].ID}, page.Next)
+}
+
+func TestService_Orders_LastPage(t *testing.T) {
+	ctx := context.Background()
+
+	c := mockservice.NewCache(t)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	r.EXPECT().Orders(ctx, model.OrderFilter{
+		IsRecent: true,
+		Limit:    101,
+	}).Return(nil, model.ErrOrderNotFound).Once()
+
+	page, err := s.Orders(ctx, model.OrderFilter{})
+
+	require.NoError(t, err)
+	require.Empty(t, page.Orders)
+	require.Nil(t, page.Next)
+}
+
 func TestService_ProcessOrder(t *testing.T) {
 	ctx := context.Background()
 	id := uuid.New()
@@ -124,7 +400,7 @@ func TestService_ProcessOrder(t *testing.T) {
 		ID: id,
 	}
 
-	r.EXPECT().CreateOrder(ctx, testOrder).Return(testOrder, nil).Once()
+	r.EXPECT().CreateOrder(mock.Anything, testOrder).Return(testOrder, nil).Once()
 
 	c.EXPECT().Set(id.String(), testOrder).Return().Once()
 
@@ -136,6 +412,18 @@ func TestService_ProcessOrder(t *testing.T) {
 	})
 }
 
+func TestService_ExpireIdempotencyKeys(t *testing.T) {
+	r := mockservice.NewRepository(t)
+	s := service.New(r, mockservice.NewCache(t), 100, service.WithIdempotency(0, time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.EXPECT().DeleteIdempotencyKeys(mock.Anything, time.Hour).
+		Run(func(context.Context, time.Duration) { cancel() }).
+		Return(3, nil).Once()
+
+	require.NoError(t, s.ExpireIdempotencyKeys(ctx, time.Millisecond))
+}
+
 func TestService_ProcessOrder_Error(t *testing.T) {
 	ctx := context.Background()
 	id := uuid.New()
@@ -145,7 +433,7 @@ func TestService_ProcessOrder_Error(t *testing.T) {
 
 	s := service.New(r, c, 100)
 
-	r.EXPECT().CreateOrder(ctx, model.Order{
+	r.EXPECT().CreateOrder(mock.Anything, model.Order{
 		ID: id,
 	}).Return(model.Order{}, pgx.ErrNoRows).Once()
 
@@ -162,6 +450,449 @@ func TestService_ProcessOrder_Error(t *testing.T) {
 	c.AssertExpectations(t)
 }
 
+func TestService_ProcessOrder_StatusTransitions(t *testing.T) {
+	ctx := context.Background()
+
+	c := mockservice.NewCache(t)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stored := createTestOrder()
+	stored.Items = append(stored.Items, stored.Items[0])
+	stored.Items[0].Status = model.Assembling
+	stored.Items[1].ID = uuid.New()
+	stored.Items[1].Status = model.Delivered
+
+	update := stored
+	update.Items = []model.OrderItem{stored.Items[0], stored.Items[1]}
+	update.Items[0].Status = model.InTransit
+	update.Items[1].Status = model.Pending
+
+	// The repository keeps the stored status of the item whose transition
+	// is not allowed, and the order it returns is what gets cached.
+	expected := update
+	expected.Items = []model.OrderItem{update.Items[0], stored.Items[1]}
+
+	rejected := testutil.ToFloat64(metrics.TransitionsRejected.WithLabelValues("delivered", "pending"))
+
+	r.EXPECT().CreateOrder(mock.Anything, update).Return(expected, nil).Once()
+	c.EXPECT().Set(stored.ID.String(), expected).Return().Once()
+
+	err := s.ProcessOrder(ctx, update)
+
+	require.NoError(t, err)
+	require.Equal(t, rejected+1,
+		testutil.ToFloat64(metrics.TransitionsRejected.WithLabelValues("delivered", "pending")))
+}
+
+func TestService_UpdateItemStatus(t *testing.T) {
+	ctx := context.Background()
+	id, rid := uuid.New(), uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stored := model.Order{ID: id, Items: []model.OrderItem{{ID: rid, Status: model.Processing, Version: 1}}}
+	updated := model.Order{ID: id, Items: []model.OrderItem{{ID: rid, Status: model.Assembling, Version: 2}}}
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Once()
+	r.EXPECT().UpdateItemStatus(mock.Anything, id, rid, model.Assembling, int64(1)).Return(nil).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{updated}, nil).Once()
+
+	order, err := s.UpdateItemStatus(ctx, id, rid, model.Assembling, "", 1)
+
+	require.NoError(t, err)
+	require.Equal(t, updated, order)
+
+	cached, ok := c.Get(id.String())
+	require.True(t, ok)
+	require.Equal(t, updated, cached)
+}
+
+func TestService_UpdateItemStatus_Unchanged(t *testing.T) {
+	id, rid := uuid.New(), uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stored := model.Order{ID: id, Items: []model.OrderItem{{ID: rid, Status: model.Assembling, Version: 2}}}
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Once()
+
+	order, err := s.UpdateItemStatus(context.Background(), id, rid, model.Assembling, "", 0)
+
+	require.NoError(t, err)
+	require.Equal(t, stored, order)
+	r.AssertNotCalled(t, "UpdateItemStatus")
+}
+
+func TestService_UpdateItemStatus_Rejected(t *testing.T) {
+	id, rid := uuid.New(), uuid.New()
+	stored := model.Order{ID: id, Items: []model.OrderItem{{ID: rid, Status: model.Delivered, Version: 5}}}
+
+	tests := map[string]struct {
+		rid      uuid.UUID
+		status   model.ItemStatus
+		reason   string
+		version  int64
+		expected error
+	}{
+		"item not found": {
+			rid:      uuid.New(),
+			status:   model.Assembling,
+			expected: model.ErrItemNotFound,
+		},
+		"stale version": {
+			rid:      rid,
+			status:   model.Returned,
+			reason:   "damaged",
+			version:  4,
+			expected: model.ErrVersionConflict,
+		},
+		"no reason": {
+			rid:      rid,
+			status:   model.Returned,
+			version:  5,
+			expected: model.ErrReasonRequired,
+		},
+		"invalid transition": {
+			rid:      rid,
+			status:   model.Assembling,
+			version:  5,
+			expected: model.ErrInvalidTransition,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := cache.New[string, model.Order](10, time.Minute)
+			r := mockservice.NewRepository(t)
+
+			s := service.New(r, c, 100)
+
+			r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+				Return([]model.Order{stored}, nil).Once()
+
+			_, err := s.UpdateItemStatus(context.Background(), id, tt.rid, tt.status, tt.reason, tt.version)
+
+			require.ErrorIs(t, err, tt.expected)
+			r.AssertNotCalled(t, "UpdateItemStatus")
+			r.AssertNotCalled(t, "ChangeItems")
+		})
+	}
+}
+
+func TestService_UpdateItemStatus_ConcurrentUpdate(t *testing.T) {
+	id, rid := uuid.New(), uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stored := model.Order{ID: id, Items: []model.OrderItem{{ID: rid, Status: model.Processing, Version: 1}}}
+	changed := model.Order{ID: id, Items: []model.OrderItem{{ID: rid, Status: model.Cancelled, Version: 2}}}
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Once()
+	r.EXPECT().UpdateItemStatus(mock.Anything, id, rid, model.Assembling, int64(1)).
+		Return(errors.WithStack(model.ErrVersionConflict)).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{changed}, nil).Once()
+
+	_, err := s.UpdateItemStatus(context.Background(), id, rid, model.Assembling, "", 0)
+
+	require.ErrorIs(t, err, model.ErrVersionConflict)
+
+	// The cache is refreshed so the client can retry with the new version.
+	cached, ok := c.Get(id.String())
+	require.True(t, ok)
+	require.Equal(t, changed, cached)
+}
+
+func TestService_CancelOrder(t *testing.T) {
+	id := uuid.New()
+	pending, assembling, cancelled := uuid.New(), uuid.New(), uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stored := model.Order{
+		ID: id,
+		Items: []model.OrderItem{
+			{ID: pending, Status: model.Pending, TotalPrice: 100, Version: 1},
+			{ID: assembling, Status: model.Assembling, TotalPrice: 200, Version: 3},
+			{ID: cancelled, Status: model.Cancelled, TotalPrice: 400, Version: 2},
+		},
+		Payment: model.Payment{DeliveryCost: 50, GoodsTotal: 300, Refund: 400},
+	}
+	updated := model.Order{ID: id}
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Once()
+	r.EXPECT().ChangeItems(mock.Anything, model.ItemsChange{
+		OrderID: id,
+		Items:   stored.Items[:2],
+		Status:  model.Cancelled,
+		Reason:  "changed my mind",
+	}).Return(nil).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{updated}, nil).Once()
+
+	order, err := s.CancelOrder(context.Background(), id, "changed my mind")
+
+	require.NoError(t, err)
+	require.Equal(t, updated, order)
+}
+
+func TestService_CancelOrder_Rejected(t *testing.T) {
+	id := uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	_, err := s.CancelOrder(context.Background(), id, "")
+	require.ErrorIs(t, err, model.ErrReasonRequired)
+
+	stored := model.Order{ID: id, Items: []model.OrderItem{
+		{ID: uuid.New(), Status: model.Pending},
+		{ID: uuid.New(), Status: model.InTransit},
+	}}
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Once()
+
+	_, err = s.CancelOrder(context.Background(), id, "changed my mind")
+
+	require.ErrorIs(t, err, model.ErrInvalidTransition)
+	r.AssertNotCalled(t, "ChangeItems")
+}
+
+func TestService_ReturnItems(t *testing.T) {
+	id := uuid.New()
+	kept, returned := uuid.New(), uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stored := model.Order{
+		ID: id,
+		Items: []model.OrderItem{
+			{ID: kept, Status: model.Delivered, TotalPrice: 100, Version: 4},
+			{ID: returned, Status: model.Delivered, TotalPrice: 200, Version: 4},
+		},
+		Payment: model.Payment{DeliveryCost: 50, GoodsTotal: 300},
+	}
+	updated := model.Order{ID: id}
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Once()
+	r.EXPECT().ChangeItems(mock.Anything, model.ItemsChange{
+		OrderID: id,
+		Items:   stored.Items[1:],
+		Status:  model.Returned,
+		Reason:  "wrong size",
+	}).Return(nil).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{updated}, nil).Once()
+
+	order, err := s.ReturnItems(context.Background(), id, []uuid.UUID{returned, returned}, "wrong size")
+
+	require.NoError(t, err)
+	require.Equal(t, updated, order)
+}
+
+func TestService_ReturnItems_Rejected(t *testing.T) {
+	id, delivered, shipped := uuid.New(), uuid.New(), uuid.New()
+	stored := model.Order{ID: id, Items: []model.OrderItem{
+		{ID: delivered, Status: model.Delivered},
+		{ID: shipped, Status: model.InTransit},
+	}}
+
+	tests := map[string]struct {
+		rids     []uuid.UUID
+		expected error
+	}{
+		"not delivered": {
+			rids:     []uuid.UUID{delivered, shipped},
+			expected: model.ErrInvalidTransition,
+		},
+		"whole order not delivered": {
+			expected: model.ErrInvalidTransition,
+		},
+		"unknown item": {
+			rids:     []uuid.UUID{uuid.New()},
+			expected: model.ErrItemNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := cache.New[string, model.Order](10, time.Minute)
+			r := mockservice.NewRepository(t)
+
+			s := service.New(r, c, 100)
+
+			r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).
+				Return([]model.Order{stored}, nil).Once()
+
+			_, err := s.ReturnItems(context.Background(), id, tt.rids, "wrong size")
+
+			require.ErrorIs(t, err, tt.expected)
+			r.AssertNotCalled(t, "ChangeItems")
+		})
+	}
+}
+
+func TestService_UpdateItemStatus_Refunded(t *testing.T) {
+	id, rid := uuid.New(), uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	stored := model.Order{ID: id, Items: []model.OrderItem{
+		{ID: rid, Status: model.Pending, TotalPrice: 100, Version: 1},
+		{ID: uuid.New(), Status: model.Pending, TotalPrice: 200, Version: 1},
+	}}
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Twice()
+	r.EXPECT().ChangeItems(mock.Anything, model.ItemsChange{
+		OrderID: id,
+		Items:   stored.Items[:1],
+		Status:  model.Cancelled,
+		Reason:  "out of stock",
+	}).Return(nil).Once()
+
+	_, err := s.UpdateItemStatus(context.Background(), id, rid, model.Cancelled, "out of stock", 1)
+
+	require.NoError(t, err)
+	r.AssertNotCalled(t, "UpdateItemStatus")
+}
+
+func TestService_ApplyStatusEvent(t *testing.T) {
+	id, rid := uuid.New(), uuid.New()
+	changed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	// The service clock is ahead of logistics, but no event has been applied yet.
+	item := model.OrderItem{ID: rid, Status: model.Processing, Version: 2, StatusChanged: changed.Add(2 * time.Hour)}
+	stored := model.Order{ID: id, Items: []model.OrderItem{item}}
+	updated := model.Order{ID: id}
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{ItemID: rid}).Return([]model.Order{stored}, nil).Once()
+	// The event skips assembling, which has not arrived yet.
+	r.EXPECT().ChangeItems(mock.Anything, model.ItemsChange{
+		OrderID:   id,
+		Items:     []model.OrderItem{item},
+		Status:    model.InTransit,
+		EventTime: changed.Add(time.Hour),
+	}).Return(nil).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{updated}, nil).Once()
+
+	err := s.ApplyStatusEvent(context.Background(), model.StatusEvent{
+		ItemID: rid,
+		Status: model.InTransit,
+		Time:   changed.Add(time.Hour),
+	})
+
+	require.NoError(t, err)
+
+	cached, ok := c.Get(id.String())
+	require.True(t, ok)
+	require.Equal(t, updated, cached)
+}
+
+func TestService_ApplyStatusEvent_Skipped(t *testing.T) {
+	rid := uuid.New()
+	changed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	stored := model.Order{ID: uuid.New(), Items: []model.OrderItem{
+		{ID: rid, Status: model.InTransit, Version: 3, StatusChanged: changed.Add(-time.Hour), StatusEvent: changed},
+	}}
+
+	tests := map[string]model.StatusEvent{
+		"older event":       {ItemID: rid, Status: model.Assembling, Time: changed.Add(-time.Minute)},
+		"same time":         {ItemID: rid, Status: model.Delivered, Time: changed},
+		"same status":       {ItemID: rid, Status: model.InTransit, Time: changed.Add(time.Minute)},
+		"not reachable":     {ItemID: rid, Status: model.Cancelled, Time: changed.Add(time.Minute)},
+		"backwards in time": {ItemID: rid, Status: model.Pending, Time: changed.Add(time.Minute)},
+	}
+
+	for name, event := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := cache.New[string, model.Order](10, time.Minute)
+			r := mockservice.NewRepository(t)
+
+			s := service.New(r, c, 100)
+
+			r.EXPECT().Orders(mock.Anything, model.OrderFilter{ItemID: rid}).
+				Return([]model.Order{stored}, nil).Once()
+
+			err := s.ApplyStatusEvent(context.Background(), event)
+
+			require.NoError(t, err)
+			r.AssertNotCalled(t, "ChangeItems")
+		})
+	}
+}
+
+func TestService_ApplyStatusEvent_Refunded(t *testing.T) {
+	id, rid := uuid.New(), uuid.New()
+	changed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	item := model.OrderItem{ID: rid, Status: model.Delivered, TotalPrice: 300, Version: 5, StatusEvent: changed}
+	stored := model.Order{ID: id, Items: []model.OrderItem{item}, Payment: model.Payment{GoodsTotal: 300}}
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{ItemID: rid}).Return([]model.Order{stored}, nil).Once()
+	r.EXPECT().ChangeItems(mock.Anything, model.ItemsChange{
+		OrderID:   id,
+		Items:     []model.OrderItem{item},
+		Status:    model.Returned,
+		EventTime: changed.Add(time.Hour),
+	}).Return(nil).Once()
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{OrderID: id}).Return([]model.Order{stored}, nil).Once()
+
+	err := s.ApplyStatusEvent(context.Background(), model.StatusEvent{
+		ItemID: rid,
+		Status: model.Returned,
+		Time:   changed.Add(time.Hour),
+	})
+
+	require.NoError(t, err)
+}
+
+func TestService_ApplyStatusEvent_UnknownItem(t *testing.T) {
+	rid := uuid.New()
+
+	c := cache.New[string, model.Order](10, time.Minute)
+	r := mockservice.NewRepository(t)
+
+	s := service.New(r, c, 100)
+
+	r.EXPECT().Orders(mock.Anything, model.OrderFilter{ItemID: rid}).
+		Return(nil, errors.WithStack(model.ErrOrderNotFound)).Once()
+
+	err := s.ApplyStatusEvent(context.Background(), model.StatusEvent{
+		ItemID: rid,
+		Status: model.Delivered,
+		Time:   time.Now(),
+	})
+
+	require.ErrorIs(t, err, model.ErrOrderNotFound)
+}
+
 func TestService_WarmUpCache(t *testing.T) {
 	ctx := context.Background()
 
@@ -186,14 +917,36 @@ func TestService_WarmUpCache(t *testing.T) {
 		c.EXPECT().Set(order.ID.String(), order).Return().Once()
 	}
 
+	require.Error(t, s.CheckWarmUp(ctx))
+
 	err := s.WarmUpCache(ctx)
 
 	require.NoError(t, err)
+	require.NoError(t, s.CheckWarmUp(ctx))
 
 	r.AssertExpectations(t)
 	c.AssertExpectations(t)
 }
 
+func TestService_WarmUpCache_EmptyDatabase(t *testing.T) {
+	ctx := context.Background()
+
+	r := mockservice.NewRepository(t)
+	c := mockservice.NewCache(t)
+
+	s := service.New(r, c, 100)
+
+	r.EXPECT().Orders(ctx, model.OrderFilter{
+		IsRecent: true,
+		Limit:    uint64(100),
+	}).Return(nil, model.ErrOrderNotFound).Once()
+
+	err := s.WarmUpCache(ctx)
+
+	require.NoError(t, err)
+	require.NoError(t, s.CheckWarmUp(ctx))
+}
+
 func TestService_WarmUpCache_Error(t *testing.T) {
 	ctx := context.Background()
 
@@ -213,11 +966,149 @@ func TestService_WarmUpCache_Error(t *testing.T) {
 
 	require.Error(t, err)
 	require.Equal(t, pgx.ErrNoRows, err)
+	require.Error(t, s.CheckWarmUp(ctx))
 
 	r.AssertExpectations(t)
 	c.AssertExpectations(t)
 }
 
+func TestService_WarmUpCache_FromSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	r := mockservice.NewRepository(t)
+	c := mockservice.NewCache(t)
+	snapshot := mockservice.NewSnapshot(t)
+
+	s := service.New(r, c, 100, service.WithSnapshot(snapshot))
+
+	snapshot.EXPECT().Restore().Return(3, nil).Once()
+
+	err := s.WarmUpCache(ctx)
+
+	require.NoError(t, err)
+	require.NoError(t, s.CheckWarmUp(ctx))
+}
+
+func TestService_WarmUpCache_SnapshotFallback(t *testing.T) {
+	tests := map[string]struct {
+		restored int
+		err      error
+	}{
+		"missing": {err: fs.ErrNotExist},
+		"corrupt": {err: errors.New("decode cache snapshot: unexpected EOF")},
+		"empty":   {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			r := mockservice.NewRepository(t)
+			c := mockservice.NewCache(t)
+			snapshot := mockservice.NewSnapshot(t)
+
+			s := service.New(r, c, 100, service.WithSnapshot(snapshot))
+
+			order := createTestOrder()
+			snapshot.EXPECT().Restore().Return(tt.restored, tt.err).Once()
+			r.EXPECT().Orders(ctx, model.OrderFilter{
+				IsRecent: true,
+				Limit:    uint64(100),
+			}).Return([]model.Order{order}, nil).Once()
+			c.EXPECT().Set(order.ID.String(), order).Return().Once()
+
+			err := s.WarmUpCache(ctx)
+
+			require.NoError(t, err)
+			require.NoError(t, s.CheckWarmUp(ctx))
+		})
+	}
+}
+
+func TestService_WarmUpCache_Paged(t *testing.T) {
+	ctx := context.Background()
+
+	r := mockservice.NewRepository(t)
+	c := mockservice.NewCache(t)
+
+	s := service.New(r, c, 100, service.WithWarmUp(2, service.RecentOrders{Limit: 5}))
+
+	orders := make([]model.Order, 5)
+	for i := range orders {
+		orders[i] = createTestOrder()
+		orders[i].Created = time.Date(2024, 1, 10-i, 0, 0, 0, 0, time.UTC)
+		c.EXPECT().Set(orders[i].ID.String(), orders[i]).Return().Once()
+	}
+	cursor := func(o model.Order) *model.OrderCursor {
+		return &model.OrderCursor{Created: o.Created, ID: o.ID}
+	}
+
+	r.EXPECT().Orders(ctx, model.OrderFilter{IsRecent: true, Limit: 2}).
+		Return(orders[0:2], nil).Once()
+	r.EXPECT().Orders(ctx, model.OrderFilter{IsRecent: true, Limit: 2, After: cursor(orders[1])}).
+		Return(orders[2:4], nil).Once()
+	r.EXPECT().Orders(ctx, model.OrderFilter{IsRecent: true, Limit: 1, After: cursor(orders[3])}).
+		Return(orders[4:5], nil).Once()
+
+	err := s.WarmUpCache(ctx)
+
+	require.NoError(t, err)
+	require.NoError(t, s.CheckWarmUp(ctx))
+}
+
+func TestService_WarmUpCache_Strategies(t *testing.T) {
+	ctx := context.Background()
+
+	r := mockservice.NewRepository(t)
+	c := mockservice.NewCache(t)
+
+	s := service.New(r, c, 100, service.WithWarmUp(10,
+		service.ActiveOrders{Limit: 10},
+		service.TouchedOrders{Window: time.Hour},
+	))
+
+	active := createTestOrder()
+	touched := createTestOrder()
+
+	r.EXPECT().Orders(ctx, model.OrderFilter{
+		ItemStatuses: model.Unfinished(),
+		IsRecent:     true,
+		Limit:        10,
+	}).Return([]model.Order{active}, nil).Once()
+	r.EXPECT().Orders(ctx, mock.MatchedBy(func(f model.OrderFilter) bool {
+		return f.IsRecent && f.Limit == 10 && time.Since(f.TouchedFrom) >= time.Hour &&
+			time.Since(f.TouchedFrom) < time.Hour+time.Minute
+	})).Return([]model.Order{active, touched}, nil).Once()
+
+	// The order found by both strategies is cached once.
+	c.EXPECT().Set(active.ID.String(), active).Return().Once()
+	c.EXPECT().Set(touched.ID.String(), touched).Return().Once()
+
+	err := s.WarmUpCache(ctx)
+
+	require.NoError(t, err)
+	require.NoError(t, s.CheckWarmUp(ctx))
+}
+
+func TestService_WarmUpCache_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := mockservice.NewRepository(t)
+	c := mockservice.NewCache(t)
+
+	s := service.New(r, c, 100, service.WithWarmUp(1, service.RecentOrders{}))
+
+	order := createTestOrder()
+	r.EXPECT().Orders(ctx, model.OrderFilter{IsRecent: true, Limit: 1}).
+		Return([]model.Order{order}, nil).Once()
+	c.EXPECT().Set(order.ID.String(), order).Run(func(string, model.Order) { cancel() }).Return().Once()
+
+	err := s.WarmUpCache(ctx)
+
+	require.ErrorIs(t, err, context.Canceled)
+	require.Error(t, s.CheckWarmUp(ctx))
+}
+
 func createTestOrder() model.Order {
 	return model.Order{
 		ID:                uuid.New(),
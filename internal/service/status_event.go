@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"order_service/internal/metrics"
+	"order_service/internal/model"
+)
+
+// ApplyStatusEvent moves a single item to the status reported by event,
+// leaving the rest of the order untouched. Events are ordered by their time:
+// an event not newer than the last event applied to the item is skipped, so
+// events delivered out of order never undo newer ones, and the statuses in
+// between may be skipped. Event times are compared only with each other, never
+// with the service clock. Events the status state machine does not allow are
+// skipped too. model.ErrOrderNotFound means the item is not known yet.
+func (s *Service) ApplyStatusEvent(ctx context.Context, event model.StatusEvent) error {
+	ctx, span := tracer.Start(ctx, "Service.ApplyStatusEvent", trace.WithAttributes(
+		attribute.String("item.rid", event.ItemID.String()),
+		attribute.String("item.status", string(event.Status)),
+	))
+	defer span.End()
+
+	err := s.applyStatusEvent(ctx, event)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) applyStatusEvent(ctx context.Context, event model.StatusEvent) error {
+	orders, err := s.repository.Orders(ctx, model.OrderFilter{ItemID: event.ItemID})
+	if err != nil {
+		return err
+	}
+	order := orders[0]
+
+	i := slices.IndexFunc(order.Items, func(item model.OrderItem) bool { return item.ID == event.ItemID })
+	if i < 0 {
+		return errors.WithDetailf(model.ErrItemNotFound, "rid %s", event.ItemID)
+	}
+	item := order.Items[i]
+
+	if !item.StatusEvent.IsZero() && !event.Time.After(item.StatusEvent) {
+		log.Info().
+			Str("rid", item.ID.String()).
+			Str("status", string(event.Status)).
+			Time("event_time", event.Time).
+			Time("last_event_time", item.StatusEvent).
+			Msg("Skipped stale item status event")
+		metrics.StatusEventsStale.Inc()
+		return nil
+	}
+
+	if item.Status == event.Status {
+		return nil
+	}
+
+	if !item.Status.CanReach(event.Status) {
+		rejectTransition(ctx, order.ID, item.ID, item.Status, event.Status,
+			errors.WithDetailf(model.ErrInvalidTransition, "%q -> %q", item.Status, event.Status))
+		return nil
+	}
+
+	_, err = s.changeItems(ctx, order, model.ItemsChange{
+		Items:     []model.OrderItem{item},
+		Status:    event.Status,
+		EventTime: event.Time,
+	})
+
+	return err
+}
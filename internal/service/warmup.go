@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"order_service/internal/model"
+)
+
+// WarmUpStrategy selects orders that WarmUpCache loads into the cache.
+type WarmUpStrategy interface {
+	Name() string
+	// Filter is evaluated once per warm-up. Its Limit caps the number of
+	// orders loaded, zero meaning all that match; orders are always loaded
+	// most recent first.
+	Filter(now time.Time) model.OrderFilter
+}
+
+// RecentOrders loads the most recently created orders.
+type RecentOrders struct {
+	Limit uint64
+}
+
+func (RecentOrders) Name() string { return "recent" }
+
+func (r RecentOrders) Filter(time.Time) model.OrderFilter {
+	return model.OrderFilter{Limit: r.Limit}
+}
+
+// ActiveOrders loads orders with an item that has not been delivered or
+// reached a final status, as those are the ones still being looked at.
+type ActiveOrders struct {
+	Limit uint64
+}
+
+func (ActiveOrders) Name() string { return "active" }
+
+func (a ActiveOrders) Filter(time.Time) model.OrderFilter {
+	return model.OrderFilter{
+		ItemStatuses: model.Unfinished(),
+		Limit:        a.Limit,
+	}
+}
+
+// TouchedOrders loads orders created or with an item status change within
+// Window.
+type TouchedOrders struct {
+	Window time.Duration
+	Limit  uint64
+}
+
+func (TouchedOrders) Name() string { return "touched" }
+
+func (t TouchedOrders) Filter(now time.Time) model.OrderFilter {
+	return model.OrderFilter{
+		TouchedFrom: now.Add(-t.Window),
+		Limit:       t.Limit,
+	}
+}
+
+// WithWarmUp makes WarmUpCache run strategies in order, querying at most
+// batchSize orders at a time. Orders loaded by later strategies end up as
+// the most recently used ones. By default the limit most recent orders are
+// loaded in a single batch.
+func WithWarmUp(batchSize uint64, strategies ...WarmUpStrategy) Option {
+	return func(s *Service) {
+		s.batchSize = batchSize
+		s.strategies = strategies
+	}
+}
+
+func (s *Service) warmUp(ctx context.Context) error {
+	seen := make(map[uuid.UUID]struct{})
+
+	for _, strategy := range s.strategies {
+		err := s.warmUpWith(ctx, strategy, seen)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().Int("orders", len(seen)).Msg("Cache warmed up from the database")
+	return nil
+}
+
+// warmUpWith pages through the orders selected by strategy, newest first,
+// skipping the ones an earlier strategy has already cached.
+func (s *Service) warmUpWith(ctx context.Context, strategy WarmUpStrategy, seen map[uuid.UUID]struct{}) error {
+	filter := strategy.Filter(time.Now())
+	filter.IsRecent = true
+	limit := filter.Limit
+
+	var fetched uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		size := s.batchSize
+		if limit > 0 && (size == 0 || limit-fetched < size) {
+			size = limit - fetched
+		}
+		filter.Limit = size
+
+		orders, err := s.repository.Orders(ctx, filter)
+		if err != nil && !errors.Is(err, model.ErrOrderNotFound) {
+			return err
+		}
+
+		for _, order := range orders {
+			if _, ok := seen[order.ID]; ok {
+				continue
+			}
+			seen[order.ID] = struct{}{}
+			s.store(order)
+		}
+		fetched += uint64(len(orders))
+
+		log.Info().
+			Str("strategy", strategy.Name()).
+			Uint64("fetched", fetched).
+			Uint64("limit", limit).
+			Msg("Cache warm-up progress")
+
+		if size == 0 || uint64(len(orders)) < size || fetched == limit {
+			return nil
+		}
+
+		last := orders[len(orders)-1]
+		filter.After = &model.OrderCursor{Created: last.Created, ID: last.ID}
+	}
+}
@@ -0,0 +1,95 @@
+package tracing_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"order_service/internal/tracing"
+)
+
+// collectorStub is a minimal OTLP/HTTP trace receiver.
+type collectorStub struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+func (c *collectorStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req collectortrace.ExportTraceServiceRequest
+	if err = proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			for _, span := range ss.GetSpans() {
+				c.spans = append(c.spans, span.GetName())
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	resp, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	_, _ = w.Write(resp)
+}
+
+func TestSetup_ExportsToCollector(t *testing.T) {
+	collector := &collectorStub{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	ctx := context.Background()
+	shutdown, err := tracing.Setup(ctx, strings.TrimPrefix(server.URL, "http://"), true)
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(ctx, "wb-orders process")
+	span.End()
+
+	require.NoError(t, shutdown(ctx))
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	require.Equal(t, []string{"wb-orders process"}, collector.spans)
+}
+
+func TestConsumerHeaders_Extract(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), "", false)
+	require.NoError(t, err)
+
+	headers := tracing.ConsumerHeaders{
+		{Key: []byte("traceparent"), Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+		nil,
+	}
+	require.Equal(t, []string{"traceparent"}, headers.Keys())
+
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headers)
+	sc := trace.SpanContextFromContext(ctx)
+
+	require.True(t, sc.IsRemote())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+
+}